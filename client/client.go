@@ -31,6 +31,7 @@ type Client interface {
 	ConfiguredModules() [][]string
 	StoredModules() ([]storage.StoredModuleInfo, error)
 	ConfiguredDownloads() [][]string
+	AdminEnabled() bool
 }
 
 func ServeClient(c Client, w http.ResponseWriter, _ *http.Request) {
@@ -41,6 +42,7 @@ func ServeClient(c Client, w http.ResponseWriter, _ *http.Request) {
 		"StoredModules":       storedModulesInfo,
 		"StoredModulesErr":    storedModulesInfoErr,
 		"ConfiguredDownloads": c.ConfiguredDownloads(),
+		"AdminEnabled":        c.AdminEnabled(),
 	}
 	logger.Type("client.ServeClient").NoErr(indexTemplate.Execute(w, values))
 }
@@ -16,10 +16,16 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-//go:embed client_test_expected_index.html
-var expectedIndexHTML string
+var (
+	//go:embed client_test_expected_index.html
+	expectedIndexHTML string
+	//go:embed client_test_expected_index_admin.html
+	expectedIndexAdminHTML string
+)
 
-type clientMock struct{}
+type clientMock struct {
+	adminEnabled bool
+}
 
 func (_ *clientMock) ConfiguredModules() [][]string {
 	return [][]string{
@@ -90,6 +96,10 @@ func (_ *clientMock) ConfiguredDownloads() [][]string {
 	}
 }
 
+func (c *clientMock) AdminEnabled() bool {
+	return c != nil && c.adminEnabled
+}
+
 func Test_mustParseTemplate(t *testing.T) {
 	assert.Panics(t, func() {
 		mustParseTemplate(nil, errors.New(""))
@@ -101,3 +111,9 @@ func Test_ServeClient(t *testing.T) {
 	ServeClient((*clientMock)(nil), w, nil)
 	assert.Equal(t, expectedIndexHTML, w.Body.String())
 }
+
+func Test_ServeClient_adminEnabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	ServeClient(&clientMock{adminEnabled: true}, w, nil)
+	assert.Equal(t, expectedIndexAdminHTML, w.Body.String())
+}
@@ -5,9 +5,15 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"go.lstv.dev/goproxy/logger"
 	"go.lstv.dev/goproxy/service"
@@ -16,22 +22,150 @@ import (
 
 func main() {
 	if len(os.Args) < 2 {
-		_, file := filepath.Split(os.Args[0])
-		fmt.Println("Usage:", file, "<config>")
+		usage()
 		return
 	}
-	c, err := service.LoadConfig(os.Args[1])
+	switch os.Args[1] {
+	case "prefetch":
+		prefetch(os.Args[2:])
+	case "schema":
+		schema()
+	case "validate":
+		validate(os.Args[2:])
+	default:
+		serve(os.Args[1:])
+	}
+}
+
+func usage() {
+	_, file := filepath.Split(os.Args[0])
+	fmt.Println("Usage:", file, "[-watch <interval>] <config>")
+	fmt.Println("      ", file, "prefetch [-latest <count>] [-concurrency <count>] <config>")
+	fmt.Println("      ", file, "schema")
+	fmt.Println("      ", file, "validate [-live] [-timeout <duration>] <config>")
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	watch := flags.Duration("watch", 0, "interval of checking configuration file for changes, 0 means reload on SIGHUP only")
+	logger.Type("main").NoErr(flags.Parse(args))
+	if flags.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	r, err := service.NewReloader(flags.Arg(0))
 	if err != nil {
 		logger.Type("main").NoErrLast(fmt.Fprintln(os.Stderr, err))
 		os.Exit(1)
 	}
-	logger.Type("main").NoErr(logger.SetLevel(c.LogLevel))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go r.Watch(ctx, *watch)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Start()
+	}()
+	select {
+	case err := <-errs:
+		logger.Type("main").Err(err).Error("failed")
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	// the second signal terminates immediately
+	stop()
+	if err := r.Shutdown(); err != nil {
+		logger.Type("main").Err(err).Error("shutdown failed")
+		os.Exit(1)
+	}
+}
+
+func prefetch(args []string) {
+	options := service.PrefetchOptions{}
+	flags := flag.NewFlagSet("prefetch", flag.ExitOnError)
+	flags.IntVar(&options.Latest, "latest", 0, "count of the latest versions per major, 0 means all versions")
+	flags.IntVar(&options.Concurrency, "concurrency", service.DefaultPrefetchConcurrency, "count of parallel downloads")
+	logger.Type("main").NoErr(flags.Parse(args))
+	if flags.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	p := newGoProxy(flags.Arg(0))
+	// downloads in progress are cancelled and cleaned up on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result := p.Prefetch(ctx, options)
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	logger.Type("main").NoErr(e.Encode(result))
+	if len(result.Failed) != 0 {
+		os.Exit(1)
+	}
+}
+
+func schema() {
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	logger.Type("main").NoErr(e.Encode(service.ConfigSchema()))
+}
+
+func validate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	live := flags.Bool("live", false, "check reachability of sources, modules and downloads")
+	timeout := flags.Duration("timeout", time.Minute, "timeout of live checks")
+	logger.Type("main").NoErr(flags.Parse(args))
+	if flags.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	config := flags.Arg(0)
+	c, err := service.LoadConfig(config)
+	if err != nil {
+		fmt.Printf("%s: invalid\n  %s\n", config, err)
+		os.Exit(1)
+	}
+	// only the report is printed
+	logger.Type("main").NoErr(logger.SetLevel(logger.Level.Error))
 	p, err := service.NewGoProxy(c)
+	if err != nil {
+		fmt.Printf("%s: invalid\n  %s\n", config, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: valid\n", config)
+	if !*live {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	failed := 0
+	for _, r := range p.Check(ctx) {
+		switch {
+		case r.Skipped:
+			fmt.Printf("  skipped %s %s\n", r.Kind, r.Name)
+		case r.Err != nil:
+			failed++
+			fmt.Printf("  failed  %s %s: %s\n", r.Kind, r.Name, r.Err)
+		default:
+			fmt.Printf("  ok      %s %s\n", r.Kind, r.Name)
+		}
+	}
+	if failed != 0 {
+		fmt.Printf("%d live checks failed\n", failed)
+		cancel()
+		os.Exit(1)
+	}
+}
+
+func newGoProxy(config string) *service.GoProxy {
+	c, err := service.LoadConfig(config)
 	if err != nil {
 		logger.Type("main").NoErrLast(fmt.Fprintln(os.Stderr, err))
 		os.Exit(1)
 	}
-	if err := p.Start(); err != nil {
-		logger.Type("main").Err(err).Error("failed")
+	logger.Type("main").NoErr(logger.SetLevel(c.LogLevel))
+	p, err := service.NewGoProxy(c)
+	if err != nil {
+		logger.Type("main").NoErrLast(fmt.Fprintln(os.Stderr, err))
+		os.Exit(1)
 	}
+	return p
 }
@@ -46,7 +46,7 @@ func (_ *formatter) Format(entry *logrus.Entry) ([]byte, error) {
 		}
 	}
 	b.WriteString("}}\r\n")
-	return b.Bytes(), nil
+	return redactJSON(b.Bytes()), nil
 }
 
 func formatTime(t time.Time) string {
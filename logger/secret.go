@@ -0,0 +1,61 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+const (
+	// Redacted replaces secret values.
+	Redacted = "[redacted]"
+
+	// minSecretLength prevents redacting of common short strings.
+	minSecretLength = 4
+)
+
+var (
+	secretsMutex sync.RWMutex
+	secrets      []string
+)
+
+// AddSecret registers secret value which is redacted from log messages and Redact results.
+// Values shorter than 4 bytes are ignored.
+func AddSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// Redact replaces registered secret values in passed string.
+func Redact(s string) string {
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return s
+}
+
+// redactJSON replaces registered secret values encoded as JSON strings in passed JSON.
+func redactJSON(b []byte) []byte {
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+	for _, secret := range secrets {
+		s := stringToJSON(secret)
+		b = bytes.ReplaceAll(b, s[1:len(s)-1], []byte(Redacted))
+	}
+	return b
+}
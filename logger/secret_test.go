@@ -0,0 +1,34 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Redact(t *testing.T) {
+	defer func() {
+		secrets = nil
+	}()
+	AddSecret("abc")
+	AddSecret("glpat-secret")
+	AddSecret("glpat-secret")
+	AddSecret(`se"cret`)
+	assert.Equal(t, []string{"glpat-secret", `se"cret`}, secrets)
+	assert.Equal(t, "abc [redacted] [redacted]", Redact(`abc glpat-secret se"cret`))
+	assertFormatter(t,
+		"{\"timestamp\":\"2022-07-08T04:05:09Z\",\"level\":\"info\",\"type\":\"[redacted]\",\"message\":\"token [redacted]\",\"data\":{\"auth\":\"[redacted]\"}}\r\n",
+		time.Date(2022, 7, 8, 4, 5, 9, 0, time.UTC),
+		logrus.InfoLevel,
+		"glpat-secret",
+		"token glpat-secret",
+		nil,
+		"auth", `se"cret`,
+	)
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/util"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+var accessLog = logger.Type("service.access")
+
+// accessEntry collects details of handled request for the access log.
+type accessEntry struct {
+	requestID string
+	action    string
+	user      string
+	module    string
+	download  string
+	version   string
+	redirect  string
+}
+
+type accessEntryKey struct{}
+
+func contextWithAccessEntry(ctx context.Context, e *accessEntry) context.Context {
+	return context.WithValue(ctx, accessEntryKey{}, e)
+}
+
+// accessEntryFromContext returns access log entry of the request, methods of nil entry are no-op.
+func accessEntryFromContext(ctx context.Context) *accessEntry {
+	e, _ := ctx.Value(accessEntryKey{}).(*accessEntry)
+	return e
+}
+
+func (e *accessEntry) setUser(user string) {
+	if e != nil {
+		e.user = user
+	}
+}
+
+func (e *accessEntry) setModule(module, version string) {
+	if e != nil {
+		e.module = module
+		e.version = version
+	}
+}
+
+func (e *accessEntry) setDownload(name, version string) {
+	if e != nil {
+		e.download = name
+		e.version = version
+	}
+}
+
+func (e *accessEntry) setRedirect(url string) {
+	if e != nil {
+		e.redirect = url
+	}
+}
+
+// requestIDOf returns ID of the request assigned by ServeHTTP, valid ID from X-Request-ID header
+// or a new generated one.
+func requestIDOf(req *http.Request) string {
+	if e := accessEntryFromContext(req.Context()); e != nil {
+		return e.requestID
+	}
+	if id := req.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	return util.GenerateUniqueID()
+}
+
+// validRequestID accepts printable ASCII IDs of limited length, so they are safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// logAccess writes one access log line of handled request.
+func logAccess(req *http.Request, e *accessEntry, w *statusWriter, start time.Time) {
+	pairs := []any{
+		"method", req.Method,
+		"url", req.URL.Path,
+		"status", w.statusCode(),
+		"bytes", w.bytes,
+		"duration", time.Since(start).Seconds(),
+		"remote_addr", req.RemoteAddr,
+		"user_agent", req.UserAgent(),
+		"action", e.action,
+	}
+	for _, f := range [][2]string{
+		{"user", e.user},
+		{"module", e.module},
+		{"download", e.download},
+		{"version", e.version},
+		{"redirect", e.redirect},
+	} {
+		if f[1] != "" {
+			pairs = append(pairs, f[0], f[1])
+		}
+	}
+	accessLog.Ctx(requestContext(req, e.requestID)).With(pairs...).Info("access")
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validRequestID(t *testing.T) {
+	cases := []struct {
+		ID    string
+		Valid bool
+	}{
+		{ID: "", Valid: false},
+		{ID: "0123456789abcdef", Valid: true},
+		{ID: "4bf92f35-77b3-4da6", Valid: true},
+		{ID: "with space", Valid: false},
+		{ID: "new\nline", Valid: false},
+		{ID: strings.Repeat("a", maxRequestIDLength), Valid: true},
+		{ID: strings.Repeat("a", maxRequestIDLength+1), Valid: false},
+	}
+	for i, c := range cases {
+		assert.Equalf(t, c.Valid, validRequestID(c.ID), "case %d", i)
+	}
+}
+
+func Test_GoProxy_ServeHTTP_accessLog(t *testing.T) {
+	output := &bytes.Buffer{}
+	logrus.SetOutput(output)
+	t.Cleanup(func() {
+		logrus.SetOutput(os.Stdout)
+	})
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		defaultGoProxyURL:   "https://proxy.golang.org",
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		modules: map[string]source.Source{
+			"example.com/a": nil,
+		},
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+
+	// valid request ID is echoed
+	req := httptest.NewRequest(http.MethodGet, "/example.com/other/@v/v1.0.0.info", http.NoBody)
+	req.Header.Set(requestIDHeader, "client-id-1")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "client-id-1", w.Header().Get(requestIDHeader))
+	entry := lastAccessLogEntry(t, output)
+	assert.Equal(t, "client-id-1", entry["request_id"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, float64(http.StatusTemporaryRedirect), entry["status"])
+	assert.Equal(t, float64(w.Body.Len()), entry["bytes"])
+	assert.Equal(t, "redirect", entry["action"])
+	assert.Equal(t, "example.com/other", entry["module"])
+	assert.Equal(t, "v1.0.0", entry["version"])
+	assert.Equal(t, "https://proxy.golang.org/example.com/other/@v/v1.0.0.info", entry["redirect"])
+
+	// invalid request ID is replaced
+	req = httptest.NewRequest(http.MethodGet, "/example.com/a/@v/list", http.NoBody)
+	req.Header.Set(requestIDHeader, "invalid id")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	requestID := w.Header().Get(requestIDHeader)
+	assert.NotEqual(t, "", requestID)
+	assert.NotEqual(t, "invalid id", requestID)
+	entry = lastAccessLogEntry(t, output)
+	assert.Equal(t, requestID, entry["request_id"])
+	assert.Equal(t, "list", entry["action"])
+	assert.NotContains(t, entry, "redirect")
+}
+
+// lastAccessLogEntry returns data of the last access log line.
+func lastAccessLogEntry(t *testing.T, output *bytes.Buffer) map[string]any {
+	entry := map[string]any(nil)
+	for _, line := range strings.Split(output.String(), "\n") {
+		l := struct {
+			Type    string         `json:"type"`
+			Message string         `json:"message"`
+			Data    map[string]any `json:"data"`
+		}{}
+		if json.Unmarshal([]byte(line), &l) == nil && l.Type == "service.access" {
+			entry = l.Data
+		}
+	}
+	require.NotNil(t, entry, "missing access log")
+	return entry
+}
@@ -0,0 +1,235 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"go.lstv.dev/goproxy/client"
+	"go.lstv.dev/goproxy/storage"
+)
+
+const (
+	principalAny        = "*"
+	principalUser       = "user:"        // HTTP Basic user of proxy
+	principalGitLabUser = "gitlab-user:" // user owning GitLab token
+	principalToken      = "token:"       // static token
+	principalScope      = "scope:"       // scope of GitLab token
+	principalGroup      = "group:"
+)
+
+// accessList restricts access to modules and downloads.
+// Modules and downloads not matching any rule are accessible by everyone.
+type accessList struct {
+	groups map[string]map[string]struct{} // group to set of principals
+	rules  []ACLRuleConfig
+}
+
+func newAccessList(config ACLConfig) (*accessList, error) {
+	if len(config.Rules) == 0 {
+		return nil, nil
+	}
+	a := &accessList{
+		groups: make(map[string]map[string]struct{}, len(config.Groups)),
+		rules:  config.Rules,
+	}
+	for group, members := range config.Groups {
+		a.groups[group] = make(map[string]struct{}, len(members))
+		for _, m := range members {
+			if err := validPrincipal(m, false); err != nil {
+				return nil, fmt.Errorf("invalid acl group %q: %w", group, err)
+			}
+			a.groups[group][m] = struct{}{}
+		}
+	}
+	for i, r := range config.Rules {
+		for _, patterns := range [][]string{r.Modules, r.Downloads} {
+			for _, p := range patterns {
+				if _, err := path.Match(strings.TrimSuffix(p, "/..."), ""); err != nil {
+					return nil, fmt.Errorf("invalid acl rule [%d]: invalid pattern %q: %w", i, p, err)
+				}
+			}
+		}
+		for _, principal := range r.Allow {
+			if err := validPrincipal(principal, true); err != nil {
+				return nil, fmt.Errorf("invalid acl rule [%d]: %w", i, err)
+			}
+			if strings.HasPrefix(principal, principalGroup) {
+				if _, ok := a.groups[principal[len(principalGroup):]]; !ok {
+					return nil, fmt.Errorf("invalid acl rule [%d]: unknown group %q", i, principal)
+				}
+			}
+		}
+	}
+	return a, nil
+}
+
+func validPrincipal(principal string, allowGroups bool) error {
+	switch {
+	case principal == principalAny:
+		return nil
+	case strings.HasPrefix(principal, principalUser) && len(principal) > len(principalUser):
+		return nil
+	case strings.HasPrefix(principal, principalGitLabUser) && len(principal) > len(principalGitLabUser):
+		return nil
+	case strings.HasPrefix(principal, principalToken) && len(principal) > len(principalToken):
+		return nil
+	case strings.HasPrefix(principal, principalScope) && len(principal) > len(principalScope):
+		return nil
+	case allowGroups && strings.HasPrefix(principal, principalGroup) && len(principal) > len(principalGroup):
+		return nil
+	default:
+		return fmt.Errorf("invalid principal %q", principal)
+	}
+}
+
+// AllowModule reports whether module without version suffix is accessible by identity.
+func (a *accessList) AllowModule(identity *Identity, module string) bool {
+	return a.allow(identity, module, func(r ACLRuleConfig) []string {
+		return r.Modules
+	})
+}
+
+// AllowDownload reports whether download is accessible by identity.
+func (a *accessList) AllowDownload(identity *Identity, name string) bool {
+	return a.allow(identity, name, func(r ACLRuleConfig) []string {
+		return r.Downloads
+	})
+}
+
+func (a *accessList) allow(identity *Identity, name string, patterns func(ACLRuleConfig) []string) bool {
+	if a == nil {
+		return true
+	}
+	restricted := false
+	for _, r := range a.rules {
+		if !matchAny(patterns(r), name) {
+			continue
+		}
+		restricted = true
+		if a.allowPrincipals(identity, r.Allow) {
+			return true
+		}
+	}
+	return !restricted
+}
+
+func (a *accessList) allowPrincipals(identity *Identity, principals []string) bool {
+	if identity == nil {
+		return false
+	}
+	for _, p := range identityPrincipals(identity) {
+		for _, principal := range principals {
+			switch {
+			case principal == principalAny || principal == p:
+				return true
+			case strings.HasPrefix(principal, principalGroup):
+				if _, ok := a.groups[principal[len(principalGroup):]][p]; ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// identityPrincipals returns principal of identity followed by principals of scopes of its token.
+func identityPrincipals(identity *Identity) []string {
+	principals := []string{identityPrincipal(identity)}
+	for _, scope := range identity.Scopes {
+		principals = append(principals, principalScope+scope)
+	}
+	return principals
+}
+
+// identityPrincipal returns principal of identity namespaced by authentication method,
+// so proxy users, GitLab users and static tokens of the same name are distinct.
+func identityPrincipal(identity *Identity) string {
+	switch identity.Method {
+	case AuthMethodToken:
+		return principalToken + identity.Name
+	case AuthMethodGitLab:
+		return principalGitLabUser + identity.Name
+	default:
+		return principalUser + identity.Name
+	}
+}
+
+// matchAny reports whether name matches any of patterns.
+// Pattern with suffix "/..." matches also all nested names.
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if prefix := strings.TrimSuffix(p, "/..."); prefix != p {
+			if ok, _ := path.Match(prefix, name); ok {
+				return true
+			}
+			for i := strings.IndexByte(name, '/'); i >= 0; i = nextSlash(name, i) {
+				if ok, _ := path.Match(prefix, name[:i]); ok {
+					return true
+				}
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func nextSlash(s string, i int) int {
+	if j := strings.IndexByte(s[i+1:], '/'); j >= 0 {
+		return i + 1 + j
+	}
+	return -1
+}
+
+// aclClient is a view of GoProxy for the client filtered by access list.
+type aclClient struct {
+	p        *GoProxy
+	identity *Identity
+}
+
+var _ client.Client = (*aclClient)(nil)
+
+func (c *aclClient) ConfiguredModules() [][]string {
+	modules := [][]string(nil)
+	for _, m := range c.p.ConfiguredModules() {
+		if c.p.acl.AllowModule(c.identity, m[0]) {
+			modules = append(modules, m)
+		}
+	}
+	return modules
+}
+
+func (c *aclClient) StoredModules() ([]storage.StoredModuleInfo, error) {
+	stored, err := c.p.StoredModules()
+	if err != nil {
+		return nil, err
+	}
+	modules := []storage.StoredModuleInfo(nil)
+	for _, m := range stored {
+		if c.p.acl.AllowModule(c.identity, m.Name) {
+			modules = append(modules, m)
+		}
+	}
+	return modules, nil
+}
+
+func (c *aclClient) ConfiguredDownloads() [][]string {
+	downloads := [][]string(nil)
+	for _, d := range c.p.ConfiguredDownloads() {
+		if c.p.acl.AllowDownload(c.identity, d[0]) {
+			downloads = append(downloads, d)
+		}
+	}
+	return downloads
+}
+
+func (c *aclClient) AdminEnabled() bool {
+	return c.p.AdminEnabled()
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_matchAny(t *testing.T) {
+	assert.True(t, matchAny([]string{"example.com/a"}, "example.com/a"))
+	assert.False(t, matchAny([]string{"example.com/a"}, "example.com/a/b"))
+	assert.True(t, matchAny([]string{"example.com/*"}, "example.com/a"))
+	assert.False(t, matchAny([]string{"example.com/*"}, "example.com/a/b"))
+	assert.True(t, matchAny([]string{"example.com/a/..."}, "example.com/a"))
+	assert.True(t, matchAny([]string{"example.com/a/..."}, "example.com/a/b/c"))
+	assert.False(t, matchAny([]string{"example.com/a/..."}, "example.com/ab"))
+	assert.True(t, matchAny([]string{"tool-*"}, "tool-secret"))
+	assert.False(t, matchAny(nil, "tool"))
+}
+
+func Test_newAccessList(t *testing.T) {
+	a, err := newAccessList(ACLConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	for i, c := range []ACLConfig{
+		{Rules: []ACLRuleConfig{{Modules: []string{"["}}}},
+		{Rules: []ACLRuleConfig{{Allow: []string{"invalid"}}}},
+		{Rules: []ACLRuleConfig{{Allow: []string{"gitlab-user:"}}}},
+		{Rules: []ACLRuleConfig{{Allow: []string{"scope:"}}}},
+		{Rules: []ACLRuleConfig{{Allow: []string{"group:missing"}}}},
+		{Groups: map[string][]string{"g": {"group:g"}}, Rules: []ACLRuleConfig{{Allow: []string{"group:g"}}}},
+	} {
+		_, err := newAccessList(c)
+		assert.Error(t, err, "case %d", i)
+	}
+}
+
+func Test_accessList_allow(t *testing.T) {
+	a, err := newAccessList(ACLConfig{
+		Groups: map[string][]string{
+			"backend": {"gitlab-user:alice", "token:ci"},
+			"readers": {"scope:read_api"},
+		},
+		Rules: []ACLRuleConfig{
+			{
+				Modules:   []string{"example.com/secret/..."},
+				Downloads: []string{"secret-*"},
+				Allow:     []string{"group:backend"},
+			},
+			{
+				Modules: []string{"example.com/secret/public"},
+				Allow:   []string{"*"},
+			},
+			{
+				Modules: []string{"example.com/registry/..."},
+				Allow:   []string{"scope:read_registry", "group:readers"},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	alice := &Identity{Name: "alice", Method: AuthMethodGitLab}
+	bob := &Identity{Name: "bob", Method: AuthMethodBasic}
+	ci := &Identity{Name: "ci", Method: AuthMethodToken}
+	userCI := &Identity{Name: "ci", Method: AuthMethodBasic}
+	userAlice := &Identity{Name: "alice", Method: AuthMethodBasic}
+
+	assert.True(t, a.AllowModule(bob, "example.com/public"))
+	assert.True(t, a.AllowModule(nil, "example.com/public"))
+	assert.True(t, a.AllowModule(alice, "example.com/secret/a"))
+	assert.True(t, a.AllowModule(ci, "example.com/secret/a"))
+	assert.False(t, a.AllowModule(bob, "example.com/secret/a"))
+	assert.False(t, a.AllowModule(userCI, "example.com/secret/a"))
+	assert.False(t, a.AllowModule(userAlice, "example.com/secret/a"))
+	assert.False(t, a.AllowModule(nil, "example.com/secret/a"))
+	assert.True(t, a.AllowModule(bob, "example.com/secret/public"))
+	assert.True(t, a.AllowDownload(alice, "secret-tool"))
+	assert.False(t, a.AllowDownload(bob, "secret-tool"))
+	assert.True(t, a.AllowDownload(bob, "tool"))
+	assert.True(t, (*accessList)(nil).AllowModule(nil, "example.com/secret/a"))
+
+	// scopes of GitLab tokens are principals directly and in groups
+	registry := &Identity{Name: "carol", Method: AuthMethodGitLab, Scopes: []string{"read_registry"}}
+	api := &Identity{Name: "dave", Method: AuthMethodGitLab, Scopes: []string{"read_api", "read_user"}}
+	assert.True(t, a.AllowModule(registry, "example.com/registry/a"))
+	assert.True(t, a.AllowModule(api, "example.com/registry/a"))
+	assert.False(t, a.AllowModule(alice, "example.com/registry/a"))
+	assert.False(t, a.AllowModule(registry, "example.com/secret/a"))
+	// static token of the scope name is not the scope
+	assert.False(t, a.AllowModule(&Identity{Name: "read_registry", Method: AuthMethodToken}, "example.com/registry/a"))
+}
+
+func Test_GoProxy_ServeHTTP_accessList(t *testing.T) {
+	auth, err := newAuthenticator(AuthConfig{
+		Users: map[string]string{
+			"alice": "a",
+			"bob":   "b",
+		},
+	}, nil)
+	require.NoError(t, err)
+	acl, err := newAccessList(ACLConfig{
+		Rules: []ACLRuleConfig{
+			{
+				Modules:   []string{"example.com/secret"},
+				Downloads: []string{"secret"},
+				Allow:     []string{"user:alice"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		defaultGoProxyURL:   "https://proxy.golang.org",
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		auth:                auth,
+		acl:                 acl,
+		modules: map[string]source.Source{
+			"example.com/secret": nil,
+		},
+	}
+
+	cases := []struct {
+		user   string
+		url    string
+		status int
+	}{
+		{user: "bob", url: "/example.com/secret/@v/list", status: http.StatusNotFound},
+		{user: "bob", url: "/example.com/secret/v2/@latest", status: http.StatusNotFound},
+		{user: "bob", url: "/example.com/public/@v/list", status: http.StatusTemporaryRedirect},
+		{user: "bob", url: "/dl/secret/1.0.0/amd64", status: http.StatusNotFound},
+		{user: "alice", url: "/example.com/secret/@v/list", status: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.url, http.NoBody)
+		req.SetBasicAuth(c.user, c.user[:1])
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, c.status, w.Code, "case %d", i)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+	"go.lstv.dev/goproxy/util"
+)
+
+const adminPathPrefix = "/admin"
+
+type prefetchStatus struct {
+	mutex    sync.Mutex
+	Running  bool            `json:"running"`
+	Started  *time.Time      `json:"started,omitempty"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	Result   *PrefetchResult `json:"result,omitempty"`
+}
+
+func (p *GoProxy) serveAdmin(w http.ResponseWriter, req *http.Request) {
+	requestID := requestIDOf(req)
+	ctx := requestContext(req, requestID)
+	log := p.log.Ctx(ctx).With(
+		"func", "serveAdmin",
+		"url", req.URL.Path,
+	)
+
+	if !p.AdminEnabled() {
+		log.Debug("admin api is not configured")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !p.isAdmin(req) {
+		log.Debug("invalid admin token")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="goproxy admin"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch req.URL.Path[len(adminPathPrefix):] {
+	case "/prefetch":
+		p.serveAdminPrefetch(ctx, w, req, requestID)
+	case "/modules":
+		p.serveAdminModules(ctx, w, req)
+	case "/modules/download":
+		p.serveAdminModuleDownload(ctx, w, req)
+	case "/downloads":
+		p.serveAdminDownloads(ctx, w, req)
+	case "/caches":
+		p.serveAdminCaches(ctx, w, req)
+	default:
+		log.Debug("unknown admin url")
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (p *GoProxy) isAdmin(req *http.Request) bool {
+	const prefix = "Bearer "
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(p.admin.Token)) == 1
+}
+
+// serveAdminPrefetch starts prefetch in the background for POST method
+// and returns status of the last prefetch for GET method.
+func (p *GoProxy) serveAdminPrefetch(ctx context.Context, w http.ResponseWriter, req *http.Request, requestID string) {
+	log := p.log.Ctx(ctx).With(
+		"func", "serveAdminPrefetch",
+	)
+	status := p.prefetch
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		status.mutex.Lock()
+		defer status.mutex.Unlock()
+		writeJSON(ctx, w, http.StatusOK, status)
+	case http.MethodPost:
+		options := PrefetchOptions{}
+		query := req.URL.Query()
+		for key, value := range map[string]*int{
+			"latest":      &options.Latest,
+			"concurrency": &options.Concurrency,
+		} {
+			if s := query.Get(key); s != "" {
+				i, err := strconv.Atoi(s)
+				if err != nil || i < 0 {
+					log.Err(err).With(
+						key, s,
+					).Debug("invalid prefetch option")
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				*value = i
+			}
+		}
+
+		status.mutex.Lock()
+		defer status.mutex.Unlock()
+		if status.Running {
+			writeJSON(ctx, w, http.StatusConflict, status)
+			return
+		}
+		started := time.Now()
+		status.Running = true
+		status.Started = &started
+		status.Finished = nil
+		status.Result = nil
+		bgCtx := logger.ContextWith(p.tasks.context(),
+			"request_id", requestID,
+		)
+		p.tasks.add()
+		go func() {
+			defer p.tasks.done()
+			result := p.Prefetch(bgCtx, options)
+			status.mutex.Lock()
+			defer status.mutex.Unlock()
+			finished := time.Now()
+			status.Running = false
+			status.Finished = &finished
+			status.Result = &result
+		}()
+		writeJSON(ctx, w, http.StatusAccepted, status)
+	default:
+		log.Debug("expected GET or POST method")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveAdminModules returns stored modules for GET method
+// and removes stored module or its version for DELETE method.
+func (p *GoProxy) serveAdminModules(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	log := p.log.Ctx(ctx).With(
+		"func", "serveAdminModules",
+	)
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		modules, err := p.StoredModules()
+		if err != nil {
+			log.Err(err).Warn("unable to get stored modules")
+			writeJSONError(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(ctx, w, http.StatusOK, modules)
+	case http.MethodDelete:
+		module := req.URL.Query().Get("module")
+		version := req.URL.Query().Get("version")
+		log = log.With(
+			"module", module,
+			"version", version,
+		)
+		err := error(nil)
+		if version == "" {
+			err = p.files.DeleteModule(module)
+		} else {
+			err = p.files.DeleteVersion(module, version)
+		}
+		if err != nil {
+			log.Err(err).Warn("unable to delete stored module")
+			writeJSONError(ctx, w, storageErrorStatusCode(err), err)
+			return
+		}
+		log.Info("deleted stored module")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		log.Debug("expected GET or DELETE method")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveAdminDownloads returns stored downloads for GET method
+// and removes stored download or its version for DELETE method.
+func (p *GoProxy) serveAdminDownloads(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	log := p.log.Ctx(ctx).With(
+		"func", "serveAdminDownloads",
+	)
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		downloads, err := p.files.StoredDownloads()
+		if err != nil {
+			log.Err(err).Warn("unable to get stored downloads")
+			writeJSONError(ctx, w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(ctx, w, http.StatusOK, downloads)
+	case http.MethodDelete:
+		name := req.URL.Query().Get("name")
+		version := req.URL.Query().Get("version")
+		log = log.With(
+			"name", name,
+			"version", version,
+		)
+		if err := p.files.DeleteDownload(name, version); err != nil {
+			log.Err(err).Warn("unable to delete stored download")
+			writeJSONError(ctx, w, storageErrorStatusCode(err), err)
+			return
+		}
+		log.Info("deleted stored download")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		log.Debug("expected GET or DELETE method")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveAdminModuleDownload downloads module version again, stored version is replaced only after successful download.
+func (p *GoProxy) serveAdminModuleDownload(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	log := p.log.Ctx(ctx).With(
+		"func", "serveAdminModuleDownload",
+	)
+	if req.Method != http.MethodPost {
+		log.Debug("expected POST method")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	module := req.URL.Query().Get("module")
+	version := req.URL.Query().Get("version")
+	log = log.With(
+		"module", module,
+		"version", version,
+	)
+	s := p.modules[util.RemoveVersionSuffix(module)]
+	if s == nil {
+		log.Debug("module is not configured")
+		writeJSONError(ctx, w, http.StatusNotFound, fmt.Errorf("module %q is not configured", module))
+		return
+	}
+	tmp, err := p.files.TempDir()
+	if err != nil {
+		log.Err(err).Warn("unable to create temporary directory")
+		writeJSONError(ctx, w, http.StatusInternalServerError, err)
+		return
+	}
+	defer func() {
+		log.NoErr(os.RemoveAll(tmp))
+	}()
+	if err := p.downloadModule(ctx, s, tmp, version); err != nil {
+		log.Err(err).Warn("unable to download module")
+		statusCode := http.StatusInternalServerError
+		if source.IsVersionNotFound(err) {
+			statusCode = http.StatusNotFound
+		}
+		writeJSONError(ctx, w, statusCode, err)
+		return
+	}
+	if err := p.files.ReplaceVersion(tmp, module, version); err != nil {
+		log.Err(err).Warn("unable to replace stored module")
+		writeJSONError(ctx, w, storageErrorStatusCode(err), err)
+		return
+	}
+	log.Info("downloaded module")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveAdminCaches drops caches of all sources, modules and downloads.
+func (p *GoProxy) serveAdminCaches(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	log := p.log.Ctx(ctx).With(
+		"func", "serveAdminCaches",
+	)
+	if req.Method != http.MethodDelete {
+		log.Debug("expected DELETE method")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p.InvalidateCaches()
+	log.Info("caches cleared")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func storageErrorStatusCode(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case storage.IsCurrentlyLocked(err):
+		return http.StatusConflict
+	case storage.IsInvalidModule(err), storage.IsInvalidDownload(err):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSONError(ctx context.Context, w http.ResponseWriter, statusCode int, err error) {
+	writeJSON(ctx, w, statusCode, map[string]string{
+		"err": err.Error(),
+	})
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, statusCode int, v any) {
+	setContentType(w, "json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Type("service.writeJSON").Ctx(ctx).Err(err).Warn("encoding failed")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GoProxy_serveAdmin_unauthorized(t *testing.T) {
+	p := &GoProxy{
+		log:      logger.Type("service.GoProxy"),
+		prefetch: &prefetchStatus{},
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/prefetch", http.NoBody))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	p.admin.Token = "secret"
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/prefetch", http.NoBody))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/prefetch", http.NoBody)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"running":false}`, w.Body.String())
+}
+
+func Test_GoProxy_serveAdminModules(t *testing.T) {
+	p := &GoProxy{
+		log: logger.Type("service.GoProxy"),
+		admin: AdminConfig{
+			Token: "secret",
+		},
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+	cases := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{method: http.MethodGet, url: "/admin/modules", status: http.StatusOK},
+		{method: http.MethodDelete, url: "/admin/modules?module=example.com/a&version=v1.0.0", status: http.StatusNotFound},
+		{method: http.MethodDelete, url: "/admin/modules?module=../a", status: http.StatusBadRequest},
+		{method: http.MethodPost, url: "/admin/modules", status: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, url: "/admin/modules/download?module=example.com/a&version=v1.0.0", status: http.StatusNotFound},
+		{method: http.MethodDelete, url: "/admin/caches", status: http.StatusNoContent},
+		{method: http.MethodGet, url: "/admin/unknown", status: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(c.method, c.url, http.NoBody)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, c.status, w.Code, "case %d", i)
+	}
+}
+
+type refreshMock struct {
+	source.Source
+	err error
+}
+
+func (s *refreshMock) DownloadModule(_ context.Context, dir, version string) error {
+	if s.err != nil {
+		return s.err
+	}
+	for _, suffix := range []string{"info", "mod", "zip"} {
+		file := filepath.Join(dir, "example.com/a", version+"."+suffix)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, []byte("new"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Test_GoProxy_serveAdminModuleDownload(t *testing.T) {
+	s := &refreshMock{}
+	p := &GoProxy{
+		log: logger.Type("service.GoProxy"),
+		admin: AdminConfig{
+			Token: "secret",
+		},
+		modules: map[string]source.Source{
+			"example.com/a": s,
+		},
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+	zip := filepath.Join(p.files.Chroot, "example.com/a/v1.0.0.zip")
+	for _, suffix := range []string{"info", "mod", "zip"} {
+		file := filepath.Join(p.files.Chroot, "example.com/a/v1.0.0."+suffix)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("old"), 0644))
+	}
+	cases := []struct {
+		err    error
+		status int
+		zip    string
+	}{
+		{err: errors.New("unavailable"), status: http.StatusInternalServerError, zip: "old"},
+		{status: http.StatusNoContent, zip: "new"},
+	}
+	for i, c := range cases {
+		s.err = c.err
+		req := httptest.NewRequest(http.MethodPost, "/admin/modules/download?module=example.com/a&version=v1.0.0", http.NoBody)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, c.status, w.Code, "case %d", i)
+		b, err := os.ReadFile(zip)
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.zip, string(b), "case %d", i)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/util"
+)
+
+// defaultArchitectureAliases maps common names of architectures (e.g. from uname -m) to names used by Go.
+var defaultArchitectureAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x64":     "amd64",
+	"aarch64": "arm64",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// canonicalArchitecture returns architecture with aliases replaced, the whole architecture
+// or its parts separated by dash are replaced, e.g. "linux-x86_64" is "linux-amd64".
+func canonicalArchitecture(aliases map[string]string, arch string) string {
+	if a, ok := aliases[arch]; ok {
+		return a
+	}
+	parts := strings.Split(arch, "-")
+	for i, part := range parts {
+		if a, ok := aliases[part]; ok {
+			parts[i] = a
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// matchArchitecture returns architecture from archs requested as arch or as its alias.
+func matchArchitecture(aliases map[string]string, archs []string, arch string) (string, bool) {
+	for _, a := range archs {
+		if a == arch {
+			return a, true
+		}
+	}
+	canonical := canonicalArchitecture(aliases, arch)
+	for _, a := range archs {
+		if canonicalArchitecture(aliases, a) == canonical {
+			return a, true
+		}
+	}
+	return "", false
+}
+
+// downloadArchitectures returns architectures of version discovered by the source and caches them for a short time.
+func (p *GoProxy) downloadArchitectures(ctx context.Context, name string, files source.DownloadFiles, v util.Version) ([]string, error) {
+	archs, err := files.DownloadArchitectures(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	p.latestDownloads.setArchitectures(name, v, archs, p.downloadOptions[name].latestCacheTTL)
+	return archs, nil
+}
+
+// listedArchitectures returns architectures of listed version and caches them for a short time.
+// Listed versions are not looked up again by sources implementing source.ListedArchitectures.
+func (p *GoProxy) listedArchitectures(ctx context.Context, name string, files source.DownloadFiles, dv source.DownloadVersion) ([]string, error) {
+	l, ok := files.(source.ListedArchitectures)
+	if !ok {
+		return p.downloadArchitectures(ctx, name, files, dv.Version)
+	}
+	archs, err := l.ListedArchitectures(ctx, dv)
+	if err != nil {
+		return nil, err
+	}
+	p.latestDownloads.setArchitectures(name, dv.Version, archs, p.downloadOptions[name].latestCacheTTL)
+	return archs, nil
+}
+
+// resolveDownloadArchitecture returns architecture of the download requested as arch or as its alias.
+// Architectures are declared by configuration or discovered by the source, cached architectures are refreshed
+// if arch is not found, so newly uploaded files are available. If architectures are not known, aliases of arch
+// are replaced. Unknown architecture results in 404 with JSON error listing valid architectures,
+// returned ok is false if the response was written.
+func (p *GoProxy) resolveDownloadArchitecture(ctx context.Context, w http.ResponseWriter, name string, ds source.Downloads, v util.Version, arch string) (string, bool) {
+	log := p.log.Ctx(ctx).With(
+		"func", "resolveDownloadArchitecture",
+	)
+	o := p.downloadOptions[name]
+	aliases := o.architectureAliases
+	if aliases == nil {
+		aliases = defaultArchitectureAliases
+	}
+	archs := o.architectures
+	if len(archs) == 0 {
+		files, ok := ds.(source.DownloadFiles)
+		if !ok {
+			return canonicalArchitecture(aliases, arch), true
+		}
+		archs, ok = p.latestDownloads.getArchitectures(name, v)
+		if _, found := matchArchitecture(aliases, archs, arch); !ok || !found {
+			var err error
+			if archs, err = p.downloadArchitectures(ctx, name, files, v); err != nil {
+				if source.IsVersionNotFound(err) {
+					log.Err(err).Debug("download version not found")
+					w.WriteHeader(http.StatusNotFound)
+					return "", false
+				}
+				// downloads are not blocked by failed discovery, the source responds to unknown architectures
+				log.Err(err).Warn("unable to get architectures of download")
+				return canonicalArchitecture(aliases, arch), true
+			}
+		}
+	}
+	if a, ok := matchArchitecture(aliases, archs, arch); ok {
+		return a, true
+	}
+	log.Debug("unknown architecture of download")
+	if archs == nil {
+		archs = []string{}
+	}
+	writeJSON(ctx, w, http.StatusNotFound, struct {
+		Err           string   `json:"err"`
+		Architectures []string `json:"architectures"`
+	}{
+		Err:           fmt.Sprintf("unknown architecture %q of %s %s", arch, name, v),
+		Architectures: archs,
+	})
+	return "", false
+}
@@ -0,0 +1,258 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.lstv.dev/goproxy/source"
+)
+
+const (
+	AuthMethodBasic  = "basic"
+	AuthMethodToken  = "token"
+	AuthMethodGitLab = "gitlab"
+
+	DefaultAuthCacheTTL = 5 * time.Minute
+)
+
+var errMissingCredentials = errors.New("missing credentials")
+
+// Identity is an authenticated client.
+type Identity struct {
+	Name   string   `json:"name"`
+	Method string   `json:"method"`
+	Scopes []string `json:"scopes,omitempty"` // scopes of GitLab token
+	// credentials are set for GitLab tokens, which may be forwarded to sources
+	credentials *source.Credentials
+}
+
+type identityCtxKeyType struct{}
+
+var identityCtxKey = identityCtxKeyType{}
+
+// IdentityFromContext returns identity of authenticated client, nil is returned for anonymous client.
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityCtxKey).(*Identity)
+	return identity
+}
+
+func contextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityCtxKey, identity)
+}
+
+// requestCredentials returns client credentials which may be forwarded to sources if authentication is not configured.
+// Otherwise, only credentials of identities authenticated by GitLab are forwarded.
+func requestCredentials(req *http.Request) (source.Credentials, bool) {
+	if user, password, ok := req.BasicAuth(); ok && password != "" {
+		return source.Credentials{
+			User:  user,
+			Token: password,
+		}, true
+	}
+	const prefix = "Bearer "
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, prefix) && len(auth) > len(prefix) {
+		return source.Credentials{
+			Token:  auth[len(prefix):],
+			Bearer: true,
+		}, true
+	}
+	return source.Credentials{}, false
+}
+
+// checkAccess verifies access of client to the source or downloads forwarding client credentials.
+func checkAccess(ctx context.Context, v any) error {
+	if c, ok := v.(source.AccessChecker); ok {
+		return c.CheckAccess(ctx)
+	}
+	return nil
+}
+
+// writeAccessError writes response for failed authentication or access check.
+// Inaccessible items are not found, so their existence does not leak.
+func writeAccessError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errMissingCredentials), errors.Is(err, source.ErrUnauthenticated):
+		w.Header().Set("WWW-Authenticate", `Basic realm="goproxy"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	case errors.Is(err, source.ErrAccessDenied):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+type authenticator struct {
+	users  map[string]string // user name to password
+	tokens map[string]string // token to token name
+	gitlab source.Authenticator
+	cache  *identityCache
+}
+
+func newAuthenticator(config AuthConfig, sources map[string]source.Source) (*authenticator, error) {
+	if len(config.Users) == 0 && len(config.Tokens) == 0 && config.GitLab == nil {
+		return nil, nil
+	}
+	a := &authenticator{
+		users:  config.Users,
+		tokens: make(map[string]string, len(config.Tokens)),
+	}
+	for name, token := range config.Tokens {
+		if token == "" {
+			return nil, fmt.Errorf("invalid auth: empty token %q", name)
+		}
+		a.tokens[token] = name
+	}
+	if config.GitLab != nil {
+		s, ok := sources[config.GitLab.Source]
+		if !ok {
+			return nil, fmt.Errorf("invalid auth: invalid gitlab source %q", config.GitLab.Source)
+		}
+		if a.gitlab, ok = s.(source.Authenticator); !ok {
+			return nil, fmt.Errorf("invalid auth: source %q is not able to authenticate", config.GitLab.Source)
+		}
+		ttl := DefaultAuthCacheTTL
+		if config.GitLab.CacheTTL != "" {
+			d, err := time.ParseDuration(config.GitLab.CacheTTL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth: invalid gitlab cache_ttl: %w", err)
+			}
+			ttl = d
+		}
+		a.cache = &identityCache{
+			ttl:        ttl,
+			identities: map[[sha256.Size]byte]identityCacheEntry{},
+		}
+	}
+	return a, nil
+}
+
+// authenticate returns identity of client.
+// HTTP Basic password is also accepted as a token.
+func (a *authenticator) authenticate(ctx context.Context, req *http.Request) (*Identity, error) {
+	if user, password, ok := req.BasicAuth(); ok {
+		if expected, ok := a.users[user]; ok && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 {
+			return &Identity{
+				Name:   user,
+				Method: AuthMethodBasic,
+			}, nil
+		}
+		identity, err := a.authenticateToken(ctx, password)
+		return withCredentials(identity, source.Credentials{
+			User:  user,
+			Token: password,
+		}), err
+	}
+	const prefix = "Bearer "
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, prefix) {
+		identity, err := a.authenticateToken(ctx, auth[len(prefix):])
+		return withCredentials(identity, source.Credentials{
+			Token:  auth[len(prefix):],
+			Bearer: true,
+		}), err
+	}
+	return nil, errMissingCredentials
+}
+
+// withCredentials returns copy of identity authenticated by GitLab with credentials, so they may be forwarded.
+// Other identities (proxy users and static tokens) are returned unchanged, their credentials are never forwarded.
+func withCredentials(identity *Identity, c source.Credentials) *Identity {
+	if identity == nil || identity.Method != AuthMethodGitLab {
+		return identity
+	}
+	i := *identity
+	i.credentials = &c
+	return &i
+}
+
+func (a *authenticator) authenticateToken(ctx context.Context, token string) (*Identity, error) {
+	if token == "" {
+		return nil, errMissingCredentials
+	}
+	for t, name := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return &Identity{
+				Name:   name,
+				Method: AuthMethodToken,
+			}, nil
+		}
+	}
+	if a.gitlab == nil {
+		return nil, source.ErrUnauthenticated
+	}
+	if identity := a.cache.get(token); identity != nil {
+		return identity, nil
+	}
+	owner, err := a.gitlab.Authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	identity := &Identity{
+		Name:   owner.User,
+		Method: AuthMethodGitLab,
+		Scopes: owner.Scopes,
+	}
+	a.cache.set(token, identity)
+	return identity, nil
+}
+
+type identityCacheEntry struct {
+	identity *Identity
+	expires  time.Time
+}
+
+// identityCache holds identities of valid tokens, tokens are stored as hashes.
+type identityCache struct {
+	mutex      sync.Mutex
+	ttl        time.Duration
+	identities map[[sha256.Size]byte]identityCacheEntry
+}
+
+func (c *identityCache) get(token string) *Identity {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := sha256.Sum256([]byte(token))
+	e, ok := c.identities[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expires) {
+		delete(c.identities, key)
+		return nil
+	}
+	return e.identity
+}
+
+func (c *identityCache) set(token string, identity *Identity) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	for key, e := range c.identities {
+		if now.After(e.expires) {
+			delete(c.identities, key)
+		}
+	}
+	c.identities[sha256.Sum256([]byte(token))] = identityCacheEntry{
+		identity: identity,
+		expires:  now.Add(c.ttl),
+	}
+}
+
+func (c *identityCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.identities = map[[sha256.Size]byte]identityCacheEntry{}
+}
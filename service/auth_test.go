@@ -0,0 +1,122 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type authenticatorMock struct {
+	source.Source
+	calls int
+}
+
+func (a *authenticatorMock) Authenticate(_ context.Context, token string) (source.TokenOwner, error) {
+	a.calls++
+	if token == "glpat-valid" {
+		return source.TokenOwner{User: "gitlab-user", Scopes: []string{"read_api"}}, nil
+	}
+	return source.TokenOwner{}, source.ErrUnauthenticated
+}
+
+func Test_authenticator_authenticate(t *testing.T) {
+	gitlab := &authenticatorMock{}
+	a, err := newAuthenticator(AuthConfig{
+		Users: map[string]string{
+			"user": "password",
+		},
+		Tokens: map[string]string{
+			"ci": "static-token",
+		},
+		GitLab: &GitLabAuthConfig{
+			Source: "gitlab",
+		},
+	}, map[string]source.Source{
+		"gitlab": gitlab,
+	})
+	require.NoError(t, err)
+
+	cases := []struct {
+		user, password string
+		bearer         string
+		identity       *Identity
+		err            error
+	}{
+		{user: "user", password: "password", identity: &Identity{Name: "user", Method: AuthMethodBasic}},
+		{user: "user", password: "invalid", err: source.ErrUnauthenticated},
+		{user: "any", password: "static-token", identity: &Identity{Name: "ci", Method: AuthMethodToken}},
+		{bearer: "static-token", identity: &Identity{Name: "ci", Method: AuthMethodToken}},
+		{bearer: "glpat-valid", identity: &Identity{Name: "gitlab-user", Method: AuthMethodGitLab, Scopes: []string{"read_api"}, credentials: &source.Credentials{Token: "glpat-valid", Bearer: true}}},
+		{user: "gitlab-ci-token", password: "glpat-valid", identity: &Identity{Name: "gitlab-user", Method: AuthMethodGitLab, Scopes: []string{"read_api"}, credentials: &source.Credentials{User: "gitlab-ci-token", Token: "glpat-valid"}}},
+		{bearer: "glpat-invalid", err: source.ErrUnauthenticated},
+		{err: errMissingCredentials},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		if c.user != "" {
+			req.SetBasicAuth(c.user, c.password)
+		}
+		if c.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+c.bearer)
+		}
+		identity, err := a.authenticate(context.Background(), req)
+		assert.ErrorIs(t, err, c.err, "case %d", i)
+		assert.Equal(t, c.identity, identity, "case %d", i)
+	}
+	// valid gitlab token is cached
+	assert.Equal(t, 3, gitlab.calls)
+}
+
+func Test_newAuthenticator(t *testing.T) {
+	a, err := newAuthenticator(AuthConfig{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, a)
+
+	_, err = newAuthenticator(AuthConfig{Tokens: map[string]string{"ci": ""}}, nil)
+	assert.Error(t, err)
+	_, err = newAuthenticator(AuthConfig{GitLab: &GitLabAuthConfig{Source: "missing"}}, nil)
+	assert.Error(t, err)
+	_, err = newAuthenticator(AuthConfig{GitLab: &GitLabAuthConfig{Source: "gitlab", CacheTTL: "x"}}, map[string]source.Source{
+		"gitlab": &authenticatorMock{},
+	})
+	assert.Error(t, err)
+}
+
+func Test_GoProxy_ServeHTTP_authentication(t *testing.T) {
+	a, err := newAuthenticator(AuthConfig{
+		Users: map[string]string{
+			"user": "password",
+		},
+	}, nil)
+	require.NoError(t, err)
+	p := &GoProxy{
+		log:  logger.Type("service.GoProxy"),
+		auth: a,
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/versions.json", http.NoBody))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="goproxy"`, w.Header().Get("WWW-Authenticate"))
+
+	req := httptest.NewRequest(http.MethodGet, "/versions.json", http.NoBody)
+	req.SetBasicAuth("user", "password")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
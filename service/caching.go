@@ -0,0 +1,184 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// immutableMaxAge is used for stored module versions which never change.
+	immutableMaxAge = 365 * 24 * time.Hour
+	// mutableMaxAge is used for list of versions and resolved latest version.
+	mutableMaxAge = time.Minute
+
+	// maxChecksumCacheEntries limits caches of checksums, the least recently used entries are evicted.
+	maxChecksumCacheEntries = 10000
+)
+
+// checksums of served files survive reloads of configuration.
+var checksums = &checksumCache{
+	entries: newLRUCache[string, checksumEntry](maxChecksumCacheEntries),
+}
+
+// lruCache is a map limited to the given number of entries, the least recently used entries are evicted.
+// It is not safe for concurrent use.
+type lruCache[K comparable, V any] struct {
+	limit   int
+	order   *list.List // of *lruEntry, the most recently used first
+	entries map[K]*list.Element
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRUCache[K comparable, V any](limit int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		limit:   limit,
+		order:   list.New(),
+		entries: map[K]*list.Element{},
+	}
+}
+
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+func (c *lruCache[K, V]) set(key K, value V) {
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	for c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+func (c *lruCache[K, V]) len() int {
+	return c.order.Len()
+}
+
+type checksumEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// checksumCache caches ETags of stored files, entries are invalidated by change of size or modification time.
+type checksumCache struct {
+	mutex   sync.Mutex
+	entries *lruCache[string, checksumEntry]
+}
+
+// etag returns strong ETag of the file content, the file is read only if its checksum is not cached.
+// The file offset is restored to the start of the file.
+func (c *checksumCache) etag(f *os.File, info os.FileInfo) (string, error) {
+	c.mutex.Lock()
+	e, ok := c.entries.get(f.Name())
+	c.mutex.Unlock()
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+		return e.etag, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	e = checksumEntry{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		etag:    contentETag(h.Sum(nil)),
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries.set(f.Name(), e)
+	return e.etag, nil
+}
+
+func contentETag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum) + `"`
+}
+
+// setCacheControl sets Cache-Control header, responses are private if clients are authenticated.
+func (p *GoProxy) setCacheControl(w http.ResponseWriter, maxAge time.Duration, immutable bool) {
+	v := "public"
+	if p.auth != nil {
+		v = "private"
+	}
+	v += ", max-age=" + formatSeconds(maxAge)
+	if immutable {
+		v += ", immutable"
+	}
+	w.Header().Set("Cache-Control", v)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+// notModified sets validators of the response and reports whether conditional request matches them,
+// then 304 Not Modified is written.
+func notModified(w http.ResponseWriter, req *http.Request, etag string, modTime time.Time) bool {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	match := false
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		match = etag != "" && etagMatch(inm, etag)
+	} else if ims := req.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		// precision of header is one second
+		match = err == nil && !modTime.Truncate(time.Second).After(t)
+	}
+	if !match {
+		return false
+	}
+	h := w.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch compares ETags of If-None-Match header by weak comparison.
+func etagMatch(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_notModified(t *testing.T) {
+	modTime := time.Date(2022, 3, 17, 10, 0, 0, 500, time.UTC)
+	cases := []struct {
+		Method   string
+		Header   map[string]string
+		Expected bool
+	}{
+		{Method: http.MethodGet, Expected: false},
+		{Method: http.MethodGet, Header: map[string]string{"If-None-Match": `"abc"`}, Expected: true},
+		{Method: http.MethodHead, Header: map[string]string{"If-None-Match": `"x", W/"abc"`}, Expected: true},
+		{Method: http.MethodGet, Header: map[string]string{"If-None-Match": "*"}, Expected: true},
+		{Method: http.MethodGet, Header: map[string]string{"If-None-Match": `"x"`}, Expected: false},
+		{Method: http.MethodPost, Header: map[string]string{"If-None-Match": `"abc"`}, Expected: false},
+		{Method: http.MethodGet, Header: map[string]string{"If-Modified-Since": "Thu, 17 Mar 2022 10:00:00 GMT"}, Expected: true},
+		{Method: http.MethodGet, Header: map[string]string{"If-Modified-Since": "Thu, 17 Mar 2022 09:59:59 GMT"}, Expected: false},
+		{Method: http.MethodGet, Header: map[string]string{"If-Modified-Since": "invalid"}, Expected: false},
+		// If-None-Match takes precedence
+		{Method: http.MethodGet, Header: map[string]string{
+			"If-None-Match":     `"x"`,
+			"If-Modified-Since": "Thu, 17 Mar 2022 10:00:00 GMT",
+		}, Expected: false},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(c.Method, "/", http.NoBody)
+		for k, v := range c.Header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		assert.Equalf(t, c.Expected, notModified(w, req, `"abc"`, modTime), "case %d", i)
+		assert.Equalf(t, `"abc"`, w.Header().Get("ETag"), "case %d", i)
+		assert.Equalf(t, "Thu, 17 Mar 2022 10:00:00 GMT", w.Header().Get("Last-Modified"), "case %d", i)
+		if c.Expected {
+			assert.Equalf(t, http.StatusNotModified, w.Code, "case %d", i)
+		}
+	}
+}
+
+func Test_GoProxy_ServeHTTP_caching(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "example.com/a"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com/a/v1.0.0.info"), []byte(`{"Version":"v1.0.0"}`), 0644))
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		modules: map[string]source.Source{
+			"example.com/a": &sourceMock{
+				versions: map[uint][]string{1: {"v1.0.0"}},
+			},
+		},
+		files: storage.Dir{
+			Chroot: dir,
+		},
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/example.com/a/@v/v1.0.0.info", http.NoBody))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+	assert.Equal(t, `"0260efc8380be821002a0de927e8150ec7092565cc20f292e83b5e5c7f4eaf0c"`, w.Header().Get("ETag"))
+	assert.NotEqual(t, "", w.Header().Get("Last-Modified"))
+
+	req := httptest.NewRequest(http.MethodGet, "/example.com/a/@v/v1.0.0.info", http.NoBody)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+
+	// failure of missing file is not cached
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/example.com/a/@v/v1.0.0.mod", http.NoBody))
+	assert.NotEqual(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Header().Get("Cache-Control"))
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/example.com/a/@v/list", http.NoBody))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+	etag := w.Header().Get("ETag")
+	assert.NotEqual(t, "", etag)
+
+	req = httptest.NewRequest(http.MethodGet, "/example.com/a/@v/list", http.NoBody)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func Test_lruCache(t *testing.T) {
+	c := newLRUCache[string, int](3)
+	c.set("a", 1)
+	c.set("b", 2)
+	c.set("c", 3)
+	// "a" is used recently, so "b" is evicted
+	v, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	c.set("d", 4)
+	assert.Equal(t, 3, c.len())
+	_, ok = c.get("b")
+	assert.False(t, ok)
+
+	// update keeps size and marks the entry as used
+	c.set("c", 5)
+	c.set("e", 6)
+	assert.Equal(t, 3, c.len())
+	for i, e := range []struct {
+		key   string
+		value int
+		ok    bool
+	}{
+		{key: "a", ok: false},
+		{key: "c", value: 5, ok: true},
+		{key: "d", value: 4, ok: true},
+		{key: "e", value: 6, ok: true},
+	} {
+		v, ok := c.get(e.key)
+		assert.Equal(t, e.ok, ok, "case %d", i)
+		assert.Equal(t, e.value, v, "case %d", i)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"sort"
+
+	"go.lstv.dev/goproxy/source"
+)
+
+const (
+	CheckKindSource    = "source"
+	CheckKindModule    = "module"
+	CheckKindDownloads = "downloads"
+)
+
+// CheckResult is a result of live check of configured source, module or downloads.
+type CheckResult struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Skipped bool   `json:"skipped,omitempty"` // check is not supported or module is disabled
+	Err     error  `json:"-"`
+}
+
+// Check verifies all configured sources, modules and downloads against upstreams.
+// Results are sorted by kind (sources, modules, downloads) and name.
+func (p *GoProxy) Check(ctx context.Context) []CheckResult {
+	results := []CheckResult(nil)
+	for _, name := range sortedKeys(p.sources) {
+		results = append(results, checkOne(ctx, CheckKindSource, name, p.sources[name]))
+	}
+	for _, name := range sortedKeys(p.modules) {
+		results = append(results, checkOne(ctx, CheckKindModule, name, p.modules[name]))
+	}
+	for _, name := range sortedKeys(p.downloads) {
+		results = append(results, checkOne(ctx, CheckKindDownloads, name, p.downloads[name]))
+	}
+	return results
+}
+
+func checkOne(ctx context.Context, kind, name string, v any) CheckResult {
+	r := CheckResult{
+		Kind: kind,
+		Name: name,
+	}
+	c, ok := v.(source.Checker)
+	if !ok {
+		r.Skipped = true
+		return r
+	}
+	r.Err = c.Check(ctx)
+	return r
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.lstv.dev/goproxy/source"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type checkerMock struct {
+	source.Source
+	err error
+}
+
+func (c *checkerMock) Check(context.Context) error {
+	return c.err
+}
+
+func Test_GoProxy_Check(t *testing.T) {
+	failed := errors.New("project 1 not found")
+	p := &GoProxy{
+		sources: map[string]source.Source{
+			"gitlab": &checkerMock{},
+		},
+		modules: map[string]source.Source{
+			"example.com/b": &checkerMock{err: failed},
+			"example.com/a": &checkerMock{},
+			"example.com":   nil,
+		},
+		downloads: map[string]source.Downloads{},
+	}
+	assert.Equal(t, []CheckResult{
+		{Kind: CheckKindSource, Name: "gitlab"},
+		{Kind: CheckKindModule, Name: "example.com", Skipped: true},
+		{Kind: CheckKindModule, Name: "example.com/a"},
+		{Kind: CheckKindModule, Name: "example.com/b", Err: failed},
+	}, p.Check(context.Background()))
+}
@@ -0,0 +1,204 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+	"go.lstv.dev/goproxy/util"
+)
+
+const (
+	// checksumSuffix is suffix of download path of SHA-256 checksum of the download.
+	checksumSuffix = ".sha256"
+	// checksumsFile is name of SHA-256 checksums of all architectures of version.
+	checksumsFile = "SHA256SUMS"
+)
+
+// signatureSuffixes are suffixes of detached signatures passed from the source.
+var signatureSuffixes = []string{".sig", ".asc"}
+
+// downloadFileSuffix returns path without suffix of checksum or signature and the suffix.
+func downloadFileSuffix(relativePath string) (string, string) {
+	for _, suffix := range append([]string{checksumSuffix}, signatureSuffixes...) {
+		if strings.HasSuffix(relativePath, suffix) {
+			return strings.TrimSuffix(relativePath, suffix), suffix
+		}
+	}
+	return relativePath, ""
+}
+
+type downloadChecksum struct {
+	sum      string // hex encoded
+	fileName string
+}
+
+// downloadChecksumCache caches checksums of downloads which are not stored.
+type downloadChecksumCache struct {
+	mutex   sync.Mutex
+	entries *lruCache[string, downloadChecksum]
+}
+
+func newDownloadChecksumCache() *downloadChecksumCache {
+	return &downloadChecksumCache{
+		entries: newLRUCache[string, downloadChecksum](maxChecksumCacheEntries),
+	}
+}
+
+func (c *downloadChecksumCache) get(key string) (downloadChecksum, bool) {
+	if c == nil {
+		return downloadChecksum{}, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.entries.get(key)
+}
+
+func (c *downloadChecksumCache) set(key string, e downloadChecksum) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries.set(key, e)
+}
+
+func (c *downloadChecksumCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = newLRUCache[string, downloadChecksum](maxChecksumCacheEntries)
+}
+
+// downloadFileName returns file name from Content-Disposition or name derived from download path.
+func downloadFileName(contentDisposition, name string, v util.Version, arch string) string {
+	if _, params, err := mime.ParseMediaType(contentDisposition); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	fileName := name + "-" + v.String()
+	if arch != "" {
+		fileName += "-" + arch
+	}
+	return fileName
+}
+
+// downloadChecksum returns SHA-256 checksum of download, stored downloads are checksummed from storage.
+func (p *GoProxy) downloadChecksum(ctx context.Context, ds source.Downloads, req *http.Request, name string, v util.Version, arch string) (downloadChecksum, error) {
+	if p.downloadOptions[name].cache {
+		f, meta, err := p.openStoredDownload(ctx, ds, req, name, v, arch)
+		if err == nil {
+			defer p.log.Ctx(ctx).NoErrClose(f)
+			info, err := f.Stat()
+			if err != nil {
+				return downloadChecksum{}, err
+			}
+			etag, err := checksums.etag(f, info)
+			if err != nil {
+				return downloadChecksum{}, err
+			}
+			return downloadChecksum{
+				sum:      strings.Trim(etag, `"`),
+				fileName: downloadFileName(meta.ContentDisposition, name, v, arch),
+			}, nil
+		}
+		if !storage.IsCurrentlyLocked(err) {
+			return downloadChecksum{}, err
+		}
+		// download is just being stored, checksum is computed from the source
+	}
+	key := name + "/" + v.String() + "/" + arch
+	if c, ok := p.downloadChecksums.get(key); ok {
+		return c, nil
+	}
+	h := sha256.New()
+	meta, err := recordDownload(ctx, ds, req, v, arch, h)
+	if err != nil {
+		return downloadChecksum{}, err
+	}
+	c := downloadChecksum{
+		sum:      hex.EncodeToString(h.Sum(nil)),
+		fileName: downloadFileName(meta.ContentDisposition, name, v, arch),
+	}
+	p.downloadChecksums.set(key, c)
+	return c, nil
+}
+
+// serveDownloadChecksums serves checksum of download or SHA256SUMS of all architectures of version
+// in format of sha256sum utility.
+func (p *GoProxy) serveDownloadChecksums(ctx context.Context, w http.ResponseWriter, req *http.Request, name string, ds source.Downloads, v util.Version, arch string, all, latest bool) {
+	log := p.log.Ctx(ctx).With(
+		"func", "serveDownloadChecksums",
+	)
+	archs := []string{arch}
+	if all {
+		files, ok := ds.(source.DownloadFiles)
+		if !ok {
+			log.Debug("architectures of downloads are not supported")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var err error
+		if archs, err = files.DownloadArchitectures(ctx, v); err != nil {
+			log.Err(err).Warn("unable to get architectures of download")
+			if source.IsVersionNotFound(err) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	buf := &bytes.Buffer{}
+	for _, arch := range archs {
+		c, err := p.downloadChecksum(ctx, ds, req, name, v, arch)
+		if err != nil {
+			writeDownloadError(log.With("arch", arch), w, err)
+			return
+		}
+		_, _ = fmt.Fprintf(buf, "%s  %s\n", c.sum, c.fileName)
+	}
+	if latest {
+		p.setCacheControl(w, mutableMaxAge, false)
+	} else {
+		p.setCacheControl(w, immutableMaxAge, true)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if notModified(w, req, contentETag(sum[:]), time.Time{}) {
+		return
+	}
+	setContentType(w, "text")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Err(err).Debug("unable to write checksums")
+	}
+}
+
+// serveDownloadSignature passes detached signature of download from the source.
+func (p *GoProxy) serveDownloadSignature(ctx context.Context, w http.ResponseWriter, req *http.Request, ds source.Downloads, v util.Version, arch, suffix string) {
+	files, ok := ds.(source.DownloadFiles)
+	if !ok {
+		p.log.Ctx(ctx).Debug("signatures of downloads are not supported")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	files.WriteDownloadFile(ctx, w, req, v, arch, suffix)
+}
@@ -5,17 +5,26 @@
 package service
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
-	"go.lstv.dev/goproxy/logger"
 	"go.lstv.dev/goproxy/util"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	Addr              string                    `json:"addr"`
+	Server            ServerConfig              `json:"server"`
+	TLS               *TLSConfig                `json:"tls"`
+	Tracing           TracingConfig             `json:"tracing"`
 	Storage           string                    `json:"storage"`
 	LogLevel          string                    `json:"log_level"`
 	Modules           []ModuleConfig            `json:"modules"`
@@ -24,6 +33,72 @@ type Config struct {
 	Versions          VersionsConfig            `json:"versions"`
 	DefaultGoProxyURL string                    `json:"default_go_proxy_url"`
 	DownloadsPrefix   string                    `json:"downloads_prefix"`
+	ExternalURL       string                    `json:"external_url"`       // base URL of the proxy for clients, e.g. behind reverse proxy
+	DownloadsSignKey  string                    `json:"downloads_sign_key"` // key of signed download redirects
+	Webhooks          WebhooksConfig            `json:"webhooks"`
+	Admin             AdminConfig               `json:"admin"`
+	Auth              AuthConfig                `json:"auth"`
+	ACL               ACLConfig                 `json:"acl"`
+}
+
+// ServerConfig contains durations, empty string means default value, "0s" disables the timeout.
+type ServerConfig struct {
+	ReadHeaderTimeout string `json:"read_header_timeout"`
+	ReadTimeout       string `json:"read_timeout"`
+	WriteTimeout      string `json:"write_timeout"`
+	IdleTimeout       string `json:"idle_timeout"`
+	ShutdownTimeout   string `json:"shutdown_timeout"` // drain period of requests in progress
+}
+
+// TLSConfig enables TLS at addr. Files are reloaded when they change.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"` // client certificates are required if set
+	MinVersion   string `json:"min_version"`    // 1.2 (default) or 1.3
+}
+
+// TracingConfig enables export of traces to OTLP/HTTP endpoint.
+type TracingConfig struct {
+	Endpoint    string            `json:"endpoint"`     // e.g. http://otel-collector:4318, tracing is disabled if empty
+	ServiceName string            `json:"service_name"` // goproxy by default
+	SampleRatio float64           `json:"sample_ratio"` // ratio of sampled root traces, 0 means 1
+	Headers     map[string]string `json:"headers"`      // e.g. authorization of collector
+}
+
+type serverTimeouts struct {
+	readHeader time.Duration
+	read       time.Duration
+	write      time.Duration
+	idle       time.Duration
+	shutdown   time.Duration
+}
+
+func (c ServerConfig) timeouts() (serverTimeouts, error) {
+	t := serverTimeouts{}
+	for _, d := range []struct {
+		key          string
+		value        string
+		defaultValue time.Duration
+		target       *time.Duration
+	}{
+		{key: "read_header_timeout", value: c.ReadHeaderTimeout, defaultValue: DefaultReadHeaderTimeout, target: &t.readHeader},
+		{key: "read_timeout", value: c.ReadTimeout, target: &t.read},
+		{key: "write_timeout", value: c.WriteTimeout, target: &t.write},
+		{key: "idle_timeout", value: c.IdleTimeout, defaultValue: DefaultIdleTimeout, target: &t.idle},
+		{key: "shutdown_timeout", value: c.ShutdownTimeout, defaultValue: DefaultShutdownTimeout, target: &t.shutdown},
+	} {
+		*d.target = d.defaultValue
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return serverTimeouts{}, fmt.Errorf("invalid server %s: %w", d.key, err)
+		}
+		*d.target = v
+	}
+	return t, nil
 }
 
 type ModuleConfig struct {
@@ -33,9 +108,15 @@ type ModuleConfig struct {
 }
 
 type DownloadConfig struct {
-	Mode         string         `json:"mode"`
-	Source       string         `json:"source"`
-	SourceParams map[string]any `json:"source_params"`
+	Mode           string            `json:"mode"`
+	Source         string            `json:"source"`
+	SourceParams   map[string]any    `json:"source_params"`
+	DisableCache   bool              `json:"disable_cache"`        // concrete versions are stored by default
+	LatestCacheTTL string            `json:"latest_cache_ttl"`     // duration of latest version caching
+	Redirect       string            `json:"redirect"`             // "upstream" (public projects only) or "signed", downloads are streamed by default
+	RedirectTTL    string            `json:"redirect_ttl"`         // validity of signed redirects
+	Architectures  []string          `json:"architectures"`        // declared architectures, discovered by the source by default
+	ArchAliases    map[string]string `json:"architecture_aliases"` // added to default aliases, e.g. "x86_64" of "amd64"
 }
 
 type VersionsConfig struct {
@@ -43,22 +124,97 @@ type VersionsConfig struct {
 	Modules []string     `json:"modules"`
 }
 
+type AuthConfig struct {
+	Users  map[string]string `json:"users"`  // user name to password
+	Tokens map[string]string `json:"tokens"` // token name to token
+	GitLab *GitLabAuthConfig `json:"gitlab"`
+}
+
+type GitLabAuthConfig struct {
+	Source   string `json:"source"`
+	CacheTTL string `json:"cache_ttl"`
+}
+
+type ACLConfig struct {
+	Groups map[string][]string `json:"groups"` // group name to principals
+	Rules  []ACLRuleConfig     `json:"rules"`
+}
+
+type ACLRuleConfig struct {
+	Modules   []string `json:"modules"`   // module patterns
+	Downloads []string `json:"downloads"` // download name patterns
+	Allow     []string `json:"allow"`     // principals
+}
+
+type AdminConfig struct {
+	Token string `json:"token"`
+}
+
+type WebhooksConfig struct {
+	GitLab *GitLabWebhookConfig `json:"gitlab"`
+}
+
+type GitLabWebhookConfig struct {
+	SecretToken string `json:"secret_token"`
+}
+
+// LoadConfig loads configuration from JSON, YAML (.yaml, .yml) or TOML (.toml) file selected by extension.
+// Unknown fields are rejected.
 func LoadConfig(file string) (*Config, error) {
-	f, err := os.Open(file)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load config: %w", err)
+	}
+	raw, err := decodeConfig(filepath.Ext(file), b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load config: %w", err)
+	}
+	b, err = json.Marshal(raw)
 	if err != nil {
 		return nil, fmt.Errorf("unable to load config: %w", err)
 	}
-	defer logger.Type("service.LoadConfig").NoErrClose(f)
-
 	c := &Config{}
-	if err := jsonUnmarshalWithNumbers(f, c); err != nil {
+	if err := jsonUnmarshalStrict(bytes.NewReader(b), c); err != nil {
+		return nil, fmt.Errorf("unable to load config: %w", err)
+	}
+	// references to environment variables and files are resolved only in credentials
+	if err := resolveSecrets(c); err != nil {
 		return nil, fmt.Errorf("unable to load config: %w", err)
 	}
 	return c, nil
 }
 
+// decodeConfig decodes configuration file content to JSON-compatible values.
+func decodeConfig(ext string, b []byte) (any, error) {
+	raw := any(nil)
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &raw); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		m := map[string]any{}
+		if err := toml.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		raw = m
+	default:
+		if err := jsonUnmarshalWithNumbers(bytes.NewReader(b), &raw); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
 func jsonUnmarshalWithNumbers(r io.Reader, v any) error {
 	d := json.NewDecoder(r)
 	d.UseNumber()
 	return d.Decode(v)
 }
+
+func jsonUnmarshalStrict(r io.Reader, v any) error {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	d.DisallowUnknownFields()
+	return d.Decode(v)
+}
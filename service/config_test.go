@@ -6,10 +6,13 @@ package service
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_jsonUnmarshalWithNumbers(t *testing.T) {
@@ -20,3 +23,52 @@ func Test_jsonUnmarshalWithNumbers(t *testing.T) {
 		"number": json.Number("1.15"),
 	}, m)
 }
+
+func Test_LoadConfig_formats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{"addr": ":8080", "modules": [{"name": "example.com/a", "source": "gitlab", "source_params": {"project_id": 1}}]}`,
+		"config.yaml": "addr: \":8080\"\nmodules:\n  - name: example.com/a\n    source: gitlab\n    source_params:\n      project_id: 1\n",
+		"config.yml":  "addr: \":8080\"\nmodules:\n  - name: example.com/a\n    source: gitlab\n    source_params:\n      project_id: 1\n",
+		"config.toml": "addr = \":8080\"\n[[modules]]\nname = \"example.com/a\"\nsource = \"gitlab\"\n[modules.source_params]\nproject_id = 1\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+		c, err := LoadConfig(file)
+		require.NoError(t, err, name)
+		assert.Equal(t, ":8080", c.Addr, name)
+		require.Len(t, c.Modules, 1, name)
+		assert.Equal(t, "example.com/a", c.Modules[0].Name, name)
+		assert.Equal(t, json.Number("1"), c.Modules[0].SourceParams["project_id"], name)
+	}
+}
+
+func Test_LoadConfig_unknownFields(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{"adr": ":8080"}`,
+		"config.yaml": "modules:\n  - name: example.com/a\n    sorce: gitlab\n",
+		"config.toml": "[downloads.tool]\nmode = \"generic-packages\"\nsource_parms = {}\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+		_, err := LoadConfig(file)
+		assert.ErrorContains(t, err, "unknown field", name)
+	}
+}
+
+func Test_LoadConfig_example(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "example-token")
+	_, err := LoadConfig("../example-config.json")
+	assert.NoError(t, err)
+}
+
+func Test_ConfigSchema(t *testing.T) {
+	b, err := json.MarshalIndent(ConfigSchema(), "", "  ")
+	require.NoError(t, err)
+	expected, err := os.ReadFile("../config.schema.json")
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(b)+"\n", "config.schema.json is outdated, run make schema")
+}
@@ -0,0 +1,768 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+	"go.lstv.dev/goproxy/util"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	DefaultLatestDownloadCacheTTL = time.Minute
+	DefaultDownloadRedirectTTL    = 5 * time.Minute
+
+	// redirectUpstream redirects clients to the download URL of the source.
+	redirectUpstream = "upstream"
+	// redirectSigned redirects clients to time-limited signed URL of stored download.
+	redirectSigned = "signed"
+
+	// versionsJSON and versionsList are names of lists of all versions of download.
+	versionsJSON = "versions.json"
+	versionsList = "list"
+
+	// latestPreRelease resolves the latest version including pre-releases.
+	latestPreRelease = "latest-prerelease"
+	// resolvedVersionHeader contains concrete version of download requested as latest or as range.
+	resolvedVersionHeader = "X-Resolved-Version"
+
+	signatureParam = "signature"
+	expiresParam   = "expires"
+)
+
+type downloadOptions struct {
+	cache               bool
+	latestCacheTTL      time.Duration
+	redirect            string
+	redirectTTL         time.Duration
+	architectures       []string          // declared architectures, discovered by the source if empty
+	architectureAliases map[string]string // default aliases if nil
+}
+
+func (c DownloadConfig) options() (downloadOptions, error) {
+	o := downloadOptions{
+		cache:          !c.DisableCache,
+		latestCacheTTL: DefaultLatestDownloadCacheTTL,
+		redirect:       c.Redirect,
+		redirectTTL:    DefaultDownloadRedirectTTL,
+	}
+	if c.LatestCacheTTL != "" {
+		d, err := time.ParseDuration(c.LatestCacheTTL)
+		if err != nil {
+			return o, fmt.Errorf("invalid latest_cache_ttl: %w", err)
+		}
+		o.latestCacheTTL = d
+	}
+	switch c.Redirect {
+	case "", redirectUpstream:
+		// no-op
+	case redirectSigned:
+		if !o.cache {
+			return o, errors.New("invalid redirect: signed redirect requires cache")
+		}
+	default:
+		return o, fmt.Errorf("invalid redirect %q", c.Redirect)
+	}
+	if c.RedirectTTL != "" {
+		d, err := time.ParseDuration(c.RedirectTTL)
+		if err != nil {
+			return o, fmt.Errorf("invalid redirect_ttl: %w", err)
+		}
+		if d <= 0 {
+			return o, errors.New("invalid redirect_ttl: expected positive duration")
+		}
+		o.redirectTTL = d
+	}
+	for _, arch := range c.Architectures {
+		if arch == "" || strings.Contains(arch, "/") {
+			return o, fmt.Errorf("invalid architecture %q", arch)
+		}
+	}
+	if len(c.Architectures) != 0 {
+		o.architectures = append([]string(nil), c.Architectures...)
+		sort.Strings(o.architectures)
+	}
+	if len(c.ArchAliases) != 0 {
+		o.architectureAliases = map[string]string{}
+		for alias, arch := range defaultArchitectureAliases {
+			o.architectureAliases[alias] = arch
+		}
+		for alias, arch := range c.ArchAliases {
+			if alias == "" || arch == "" {
+				return o, fmt.Errorf("invalid architecture alias %q of %q", alias, arch)
+			}
+			o.architectureAliases[alias] = arch
+		}
+	}
+	return o, nil
+}
+
+// splitDownloadPath splits path /{name}/{version}[/{arch}] relative to downloads prefix.
+func splitDownloadPath(relativePath string) (name, version, arch string, ok bool) {
+	parts := strings.Split(relativePath, "/")
+	switch len(parts) {
+	case 3:
+		parts = append(parts, "")
+	case 4:
+		// no-op
+	default:
+		return "", "", "", false
+	}
+	if parts[0] != "" {
+		return "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], true
+}
+
+type latestDownloadEntry struct {
+	version util.Version
+	expires time.Time
+}
+
+type downloadVersionsEntry struct {
+	versions []source.DownloadVersion
+	expires  time.Time
+}
+
+type downloadArchitecturesEntry struct {
+	archs   []string
+	expires time.Time
+}
+
+// latestDownloadCache caches latest versions, lists of versions and architectures of downloads for a short time.
+type latestDownloadCache struct {
+	mutex         sync.Mutex
+	entries       map[string]latestDownloadEntry
+	versions      map[string]downloadVersionsEntry
+	architectures map[string]downloadArchitecturesEntry // by name and version
+}
+
+func newLatestDownloadCache() *latestDownloadCache {
+	return &latestDownloadCache{
+		entries:       map[string]latestDownloadEntry{},
+		versions:      map[string]downloadVersionsEntry{},
+		architectures: map[string]downloadArchitecturesEntry{},
+	}
+}
+
+func (c *latestDownloadCache) get(name string) (util.Version, bool) {
+	if c == nil {
+		return util.Version{}, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[name]
+	if !ok || time.Now().After(e.expires) {
+		return util.Version{}, false
+	}
+	return e.version, true
+}
+
+func (c *latestDownloadCache) set(name string, v util.Version, ttl time.Duration) {
+	if c == nil || ttl <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[name] = latestDownloadEntry{
+		version: v,
+		expires: time.Now().Add(ttl),
+	}
+}
+
+func (c *latestDownloadCache) getVersions(name string) ([]source.DownloadVersion, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.versions[name]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.versions, true
+}
+
+func (c *latestDownloadCache) setVersions(name string, versions []source.DownloadVersion, ttl time.Duration) {
+	if c == nil || ttl <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.versions[name] = downloadVersionsEntry{
+		versions: versions,
+		expires:  time.Now().Add(ttl),
+	}
+}
+
+func (c *latestDownloadCache) getArchitectures(name string, v util.Version) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.architectures[name+"/"+v.String()]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.archs, true
+}
+
+func (c *latestDownloadCache) setArchitectures(name string, v util.Version, archs []string, ttl time.Duration) {
+	if c == nil || ttl <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.architectures[name+"/"+v.String()] = downloadArchitecturesEntry{
+		archs:   archs,
+		expires: time.Now().Add(ttl),
+	}
+}
+
+func (c *latestDownloadCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = map[string]latestDownloadEntry{}
+	c.versions = map[string]downloadVersionsEntry{}
+	c.architectures = map[string]downloadArchitecturesEntry{}
+}
+
+// latestDownloadVersion returns latest version of the download resolved through short-lived cache.
+func (p *GoProxy) latestDownloadVersion(ctx context.Context, name string, ds source.Downloads) (util.Version, error) {
+	if v, ok := p.latestDownloads.get(name); ok {
+		return v, nil
+	}
+	v, err := ds.LatestDownloadVersion(ctx)
+	if err != nil {
+		return util.Version{}, err
+	}
+	p.latestDownloads.set(name, v, p.downloadOptions[name].latestCacheTTL)
+	return v, nil
+}
+
+// downloadVersions returns all versions of the download through short-lived cache.
+func (p *GoProxy) downloadVersions(ctx context.Context, name string, ds source.Downloads) ([]source.DownloadVersion, error) {
+	if versions, ok := p.latestDownloads.getVersions(name); ok {
+		return versions, nil
+	}
+	versions, err := ds.ListDownloadVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.latestDownloads.setVersions(name, versions, p.downloadOptions[name].latestCacheTTL)
+	return versions, nil
+}
+
+// versionsWithArchitectures returns copy of versions with unknown architectures discovered by the source.
+// Architectures are looked up only for listing, resolution of versions needs only the versions.
+func (p *GoProxy) versionsWithArchitectures(ctx context.Context, name string, ds source.Downloads, versions []source.DownloadVersion) ([]source.DownloadVersion, error) {
+	files, ok := ds.(source.DownloadFiles)
+	if !ok {
+		return versions, nil
+	}
+	result := make([]source.DownloadVersion, len(versions))
+	for i, dv := range versions {
+		if dv.Architectures == nil {
+			archs, ok := p.latestDownloads.getArchitectures(name, dv.Version)
+			if !ok {
+				var err error
+				if archs, err = p.listedArchitectures(ctx, name, files, dv); err != nil {
+					return nil, err
+				}
+			}
+			dv.Architectures = archs
+		}
+		result[i] = dv
+	}
+	return result, nil
+}
+
+// resolveDownloadVersion resolves version of download path, which is concrete version, "latest",
+// "latest-prerelease" or constraint (e.g. "^1.4") resolved against all versions of the download.
+// Returned resolved is false for concrete version.
+func (p *GoProxy) resolveDownloadVersion(ctx context.Context, name string, ds source.Downloads, version string) (v util.Version, resolved bool, err error) {
+	if version == "latest" {
+		v, err = p.latestDownloadVersion(ctx, name, ds)
+		return v, true, err
+	}
+	if v, err := util.ParseVersion(version); err == nil {
+		return v, false, nil
+	}
+	constraint := util.Constraint{}
+	if version != latestPreRelease {
+		if constraint, err = util.ParseConstraint(version); err != nil {
+			return util.Version{}, true, err
+		}
+	}
+	versions, err := p.downloadVersions(ctx, name, ds)
+	if err != nil {
+		return util.Version{}, true, err
+	}
+	found := false
+	for _, dv := range versions {
+		if version == latestPreRelease || constraint.Match(dv.Version) {
+			if !found || dv.Version.Compare(v) > 0 {
+				v, found = dv.Version, true
+			}
+		}
+	}
+	if !found {
+		return util.Version{}, true, source.NewVersionNotFoundError(fmt.Errorf("no version matches %q", version))
+	}
+	return v, true, nil
+}
+
+// serveDownloadVersionList serves all versions of the download as JSON or as text list of versions.
+func (p *GoProxy) serveDownloadVersionList(ctx context.Context, w http.ResponseWriter, req *http.Request, name string, ds source.Downloads, asJSON bool) {
+	log := p.log.Ctx(ctx).With(
+		"func", "serveDownloadVersionList",
+	)
+	versions, err := p.downloadVersions(ctx, name, ds)
+	if err == nil && asJSON {
+		versions, err = p.versionsWithArchitectures(ctx, name, ds, versions)
+	}
+	if err != nil {
+		log.Err(err).Warn("unable to list download versions")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	buf := &bytes.Buffer{}
+	if asJSON {
+		result := struct {
+			Versions []source.DownloadVersion `json:"versions"`
+		}{
+			Versions: versions,
+		}
+		if result.Versions == nil {
+			result.Versions = []source.DownloadVersion{}
+		}
+		if err := json.NewEncoder(buf).Encode(result); err != nil {
+			log.Err(err).Warn("download versions encoding failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		for _, v := range versions {
+			buf.WriteString(v.Version.String() + "\n")
+		}
+	}
+	p.setCacheControl(w, mutableMaxAge, false)
+	sum := sha256.Sum256(buf.Bytes())
+	if notModified(w, req, contentETag(sum[:]), time.Time{}) {
+		return
+	}
+	if asJSON {
+		setContentType(w, "json")
+	} else {
+		setContentType(w, "text")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Err(err).Debug("unable to write download versions")
+	}
+}
+
+// downloadStatusError is returned if the source responds to download by unexpected status code.
+type downloadStatusError struct {
+	statusCode int
+}
+
+func (e *downloadStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.statusCode)
+}
+
+// downloadRecorder is response writer storing written download.
+type downloadRecorder struct {
+	header  http.Header
+	status  int
+	w       io.Writer
+	written int64
+	err     error // the first write error or error of aborted download
+
+	// client receives the download while it is stored, start sets its headers before the first byte
+	client    http.ResponseWriter
+	start     func(h http.Header)
+	started   bool
+	clientErr error
+}
+
+func (r *downloadRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *downloadRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *downloadRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if r.status != http.StatusOK {
+		return len(b), nil
+	}
+	n, err := r.w.Write(b)
+	r.written += int64(n)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	if r.client != nil && r.clientErr == nil {
+		r.startClient()
+		// disconnected client cancels the context, the download is not stored then
+		_, r.clientErr = r.client.Write(b[:n])
+	}
+	return n, err
+}
+
+// startClient writes headers of successful download to the client once.
+func (r *downloadRecorder) startClient() {
+	if r.started {
+		return
+	}
+	r.started = true
+	h := r.client.Header()
+	for _, k := range []string{"Content-Type", "Content-Disposition", "Content-Length"} {
+		if v := r.header.Get(k); v != "" {
+			h.Set(k, v)
+		}
+	}
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	r.start(h)
+	r.client.WriteHeader(http.StatusOK)
+}
+
+// AbortDownload implements source.DownloadAborter, aborted download is not stored.
+func (r *downloadRecorder) AbortDownload(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// recordDownload writes the whole file from the source into w.
+func recordDownload(ctx context.Context, ds source.Downloads, req *http.Request, v util.Version, arch string, w io.Writer) (storage.DownloadMeta, error) {
+	// conditional and range headers of client are not passed
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, req.URL.String(), http.NoBody)
+	if err != nil {
+		return storage.DownloadMeta{}, err
+	}
+	return record(ctx, ds, r, v, arch, &downloadRecorder{
+		header: http.Header{},
+		w:      w,
+	})
+}
+
+// record writes download from the source into the recorder, see recordDownload.
+func record(ctx context.Context, ds source.Downloads, r *http.Request, v util.Version, arch string, rec *downloadRecorder) (storage.DownloadMeta, error) {
+	ds.WriteDownload(ctx, rec, r, v, arch)
+	if rec.status != http.StatusOK && rec.status != 0 {
+		return storage.DownloadMeta{}, &downloadStatusError{statusCode: rec.status}
+	}
+	if rec.err != nil {
+		return storage.DownloadMeta{}, rec.err
+	}
+	if err := ctx.Err(); err != nil {
+		// download was interrupted
+		return storage.DownloadMeta{}, err
+	}
+	// truncated download is not stored even if the source does not report it
+	if l := rec.header.Get("Content-Length"); l != "" {
+		if n, err := strconv.ParseInt(l, 10, 64); err == nil && n != rec.written {
+			return storage.DownloadMeta{}, fmt.Errorf("incomplete download: written %d of %d bytes", rec.written, n)
+		}
+	}
+	return storage.DownloadMeta{
+		ContentType:        rec.header.Get("Content-Type"),
+		ContentDisposition: rec.header.Get("Content-Disposition"),
+	}, nil
+}
+
+// storeDownload downloads the whole file from the source into storage.
+func (p *GoProxy) storeDownload(ctx context.Context, ds source.Downloads, req *http.Request, name string, v util.Version, arch string) error {
+	return p.files.StoreDownload(name, v.String(), arch, func(w io.Writer) (storage.DownloadMeta, error) {
+		return recordDownload(ctx, ds, req, v, arch, w)
+	})
+}
+
+// openStoredDownload opens download from storage, missing download is stored first.
+// Error storage.ErrCurrentlyLocked is returned if the download is just being stored by another request.
+func (p *GoProxy) openStoredDownload(ctx context.Context, ds source.Downloads, req *http.Request, name string, v util.Version, arch string) (*os.File, storage.DownloadMeta, error) {
+	f, meta, err := p.files.OpenDownload(name, v.String(), arch)
+	if errors.Is(err, os.ErrNotExist) {
+		storageLookupsTotal.WithLabelValues("miss").Inc()
+		if err = p.storeDownload(ctx, ds, req, name, v, arch); err == nil {
+			p.log.Ctx(ctx).Debug("download stored")
+			f, meta, err = p.files.OpenDownload(name, v.String(), arch)
+		}
+	} else if err == nil {
+		storageLookupsTotal.WithLabelValues("hit").Inc()
+	}
+	return f, meta, err
+}
+
+// writeDownloadError writes status code of failed download from the source.
+func writeDownloadError(log logger.Logger, w http.ResponseWriter, err error) {
+	log.Err(err).Warn("unable to download")
+	statusError := (*downloadStatusError)(nil)
+	switch {
+	case errors.As(err, &statusError):
+		w.WriteHeader(statusError.statusCode)
+	case storage.IsInvalidDownload(err):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// streamNewDownload stores missing download while it is streamed to the client of GET request,
+// so the client does not wait until the whole download is stored.
+func (p *GoProxy) streamNewDownload(ctx context.Context, w http.ResponseWriter, req *http.Request, name string, ds source.Downloads, v util.Version, arch string, latest bool) {
+	log := p.log.Ctx(ctx).With(
+		"func", "streamNewDownload",
+	)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, req.URL.String(), http.NoBody)
+	if err != nil {
+		writeDownloadError(log, w, err)
+		return
+	}
+	rec := &downloadRecorder{
+		header: http.Header{},
+		client: w,
+		start: func(http.Header) {
+			if latest {
+				p.setCacheControl(w, mutableMaxAge, false)
+			} else {
+				p.setCacheControl(w, immutableMaxAge, true)
+			}
+		},
+	}
+	err = p.files.StoreDownload(name, v.String(), arch, func(tmp io.Writer) (storage.DownloadMeta, error) {
+		rec.w = tmp
+		return record(ctx, ds, r, v, arch, rec)
+	})
+	switch {
+	case err == nil:
+		log.Debug("download stored")
+		// empty download
+		rec.startClient()
+	case storage.IsCurrentlyLocked(err):
+		log.Debug("download is locked, stream from source")
+		ds.WriteDownload(ctx, w, req, v, arch)
+	case !rec.started:
+		writeDownloadError(log, w, err)
+	default:
+		// client must not accept truncated download
+		log.Err(err).Warn("download failed after response was started")
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// serveStoredDownload serves download from storage. Missing download is stored while it is streamed
+// to the client of GET request, or it is stored first for HEAD and range requests.
+// If the download is just being stored by another request, it is streamed from the source.
+func (p *GoProxy) serveStoredDownload(ctx context.Context, w http.ResponseWriter, req *http.Request, name string, ds source.Downloads, v util.Version, arch string, latest bool) {
+	log := p.log.Ctx(ctx).With(
+		"func", "serveStoredDownload",
+	)
+	f, meta, err := p.files.OpenDownload(name, v.String(), arch)
+	if errors.Is(err, os.ErrNotExist) {
+		storageLookupsTotal.WithLabelValues("miss").Inc()
+		if req.Method == http.MethodGet && req.Header.Get("Range") == "" {
+			p.streamNewDownload(ctx, w, req, name, ds, v, arch, latest)
+			return
+		}
+		if err = p.storeDownload(ctx, ds, req, name, v, arch); err == nil {
+			log.Debug("download stored")
+			f, meta, err = p.files.OpenDownload(name, v.String(), arch)
+		}
+	} else if err == nil {
+		storageLookupsTotal.WithLabelValues("hit").Inc()
+	}
+	if storage.IsCurrentlyLocked(err) {
+		log.Debug("download is locked, stream from source")
+		ds.WriteDownload(ctx, w, req, v, arch)
+		return
+	}
+	if err != nil {
+		writeDownloadError(log, w, err)
+		return
+	}
+	defer log.NoErrClose(f)
+	info, err := f.Stat()
+	etag := ""
+	if err == nil {
+		etag, err = checksums.etag(f, info)
+	}
+	if err != nil {
+		log.Err(err).Warn("unable to read stored download")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if latest {
+		p.setCacheControl(w, mutableMaxAge, false)
+	} else {
+		p.setCacheControl(w, immutableMaxAge, true)
+	}
+	h := w.Header()
+	h.Set("ETag", etag)
+	if meta.ContentType != "" {
+		h.Set("Content-Type", meta.ContentType)
+	} else {
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	if meta.ContentDisposition != "" {
+		h.Set("Content-Disposition", meta.ContentDisposition)
+	}
+	http.ServeContent(w, req, "", info.ModTime(), f)
+}
+
+// downloadSignature returns signature of download path valid until expires.
+func (p *GoProxy) downloadSignature(relativePath string, expires int64) string {
+	mac := hmac.New(sha256.New, p.downloadsSignKey)
+	_, _ = mac.Write([]byte(relativePath + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedDownloadURL returns path of download with signature valid for ttl.
+func (p *GoProxy) signedDownloadURL(name string, v util.Version, arch string, ttl time.Duration) string {
+	relativePath := "/" + name + "/" + v.String()
+	if arch != "" {
+		relativePath += "/" + arch
+	}
+	expires := time.Now().Add(ttl).Unix()
+	query := url.Values{}
+	query.Set(expiresParam, strconv.FormatInt(expires, 10))
+	query.Set(signatureParam, p.downloadSignature(relativePath, expires))
+	return p.downloadsPathPrefix + relativePath + "?" + query.Encode()
+}
+
+// validDownloadSignature reports whether the request contains valid and not expired signature of its path.
+func (p *GoProxy) validDownloadSignature(req *http.Request, relativePath string) bool {
+	if len(p.downloadsSignKey) == 0 {
+		return false
+	}
+	query := req.URL.Query()
+	expires, err := strconv.ParseInt(query.Get(expiresParam), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	signature, err := hex.DecodeString(query.Get(signatureParam))
+	if err != nil {
+		return false
+	}
+	expected, _ := hex.DecodeString(p.downloadSignature(relativePath, expires))
+	return hmac.Equal(signature, expected)
+}
+
+// redirectDownload redirects client to upstream URL or to signed URL of stored download.
+func (p *GoProxy) redirectDownload(ctx context.Context, w http.ResponseWriter, req *http.Request, name string, ds source.Downloads, v util.Version, arch string) {
+	log := p.log.Ctx(ctx).With(
+		"func", "redirectDownload",
+	)
+	options := p.downloadOptions[name]
+	location := ""
+	switch options.redirect {
+	case redirectUpstream:
+		u, err := ds.(source.DownloadLocator).DownloadURL(ctx, v, arch)
+		if err != nil {
+			log.Err(err).Warn("unable to get download url")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		location = u
+		accessEntryFromContext(ctx).setRedirect(u)
+	case redirectSigned:
+		// download is stored with credentials of the client, signed request is served from storage
+		f, _, err := p.openStoredDownload(ctx, ds, req, name, v, arch)
+		if err != nil && !storage.IsCurrentlyLocked(err) {
+			writeDownloadError(log, w, err)
+			return
+		}
+		if f != nil {
+			log.NoErrClose(f)
+		}
+		location = p.signedDownloadURL(name, v, arch, options.redirectTTL)
+		// signature is not logged
+		accessEntryFromContext(ctx).setRedirect(location[:strings.Index(location, "?")])
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	log.With(
+		"redirect", options.redirect,
+	).Debug("redirect download")
+	http.Redirect(w, req, location, http.StatusTemporaryRedirect)
+}
+
+// serveSignedDownload serves stored download to request with signed URL without authentication.
+func (p *GoProxy) serveSignedDownload(ctx context.Context, w http.ResponseWriter, req *http.Request, relativePath string) {
+	log := p.log.Ctx(ctx).With(
+		"download_path", relativePath,
+	)
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		log.Debug("expected GET or HEAD method")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !p.validDownloadSignature(req, relativePath) {
+		log.Debug("invalid download signature")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	name, version, arch, ok := splitDownloadPath(relativePath)
+	ds, exists := p.downloads[name]
+	if !ok || !exists || p.downloadOptions[name].redirect != redirectSigned {
+		log.Debug("invalid signed download")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	v, err := util.ParseVersion(version)
+	if err != nil {
+		log.Err(err).Debug("invalid download version")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	accessEntryFromContext(ctx).setDownload(name, v.String())
+
+	g := downloadsInFlight.WithLabelValues("download")
+	g.Inc()
+	defer g.Dec()
+	ctx, span := startSpan(ctx, "WriteDownload",
+		attribute.String("name", name),
+		attribute.String("version", v.String()),
+		attribute.String("arch", arch),
+	)
+	defer endSpan(span, nil)
+	p.serveStoredDownload(ctx, w, req, name, ds, v, arch, false)
+}
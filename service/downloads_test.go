@@ -0,0 +1,674 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+	"go.lstv.dev/goproxy/util"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type downloadsMock struct {
+	source.Downloads
+	latest   util.Version
+	content  map[string]string // version/arch to content
+	writes   int
+	latests  int
+	lists    int
+	versions []source.DownloadVersion
+	requests []*http.Request
+	lookups  int // architectures lookups of filesMock
+}
+
+func (d *downloadsMock) WriteDownload(_ context.Context, w http.ResponseWriter, req *http.Request, v util.Version, arch string) {
+	d.writes++
+	d.requests = append(d.requests, req)
+	content, ok := d.content[v.String()+"/"+arch]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	_, _ = w.Write([]byte(content))
+}
+
+func (d *downloadsMock) LatestDownloadVersion(context.Context) (util.Version, error) {
+	d.latests++
+	return d.latest, nil
+}
+
+func (d *downloadsMock) ListDownloadVersions(context.Context) ([]source.DownloadVersion, error) {
+	d.lists++
+	return d.versions, nil
+}
+
+func Test_GoProxy_serveDownload_cache(t *testing.T) {
+	latest, err := util.ParseVersion("1.1.0")
+	require.NoError(t, err)
+	ds := &downloadsMock{
+		latest: latest,
+		content: map[string]string{
+			"1.0.0/linux-amd64": "v1.0.0",
+			"1.1.0/linux-amd64": "v1.1.0",
+		},
+	}
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		downloads: map[string]source.Downloads{
+			"tool": ds,
+		},
+		downloadOptions: map[string]downloadOptions{
+			"tool": {cache: true, latestCacheTTL: DefaultLatestDownloadCacheTTL},
+		},
+		latestDownloads: newLatestDownloadCache(),
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+	serve := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		w := serve("/dl/tool/1.0.0/linux-amd64", map[string]string{"Range": "bytes=1-"})
+		assert.Equalf(t, http.StatusPartialContent, w.Code, "request %d", i)
+		assert.Equalf(t, "1.0.0", w.Body.String(), "request %d", i)
+		assert.Equalf(t, "application/gzip", w.Header().Get("Content-Type"), "request %d", i)
+		assert.Equalf(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"), "request %d", i)
+	}
+	// the whole file is stored only once
+	assert.Equal(t, 1, ds.writes)
+	assert.Equal(t, "", ds.requests[0].Header.Get("Range"))
+
+	for i := 0; i < 2; i++ {
+		w := serve("/dl/tool/latest/linux-amd64", nil)
+		assert.Equalf(t, http.StatusOK, w.Code, "request %d", i)
+		assert.Equalf(t, "v1.1.0", w.Body.String(), "request %d", i)
+		assert.Equalf(t, "public, max-age=60", w.Header().Get("Cache-Control"), "request %d", i)
+	}
+	assert.Equal(t, 2, ds.writes)
+	assert.Equal(t, 1, ds.latests)
+
+	// missing download is not stored
+	w := serve("/dl/tool/2.0.0/linux-amd64", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	stored, err := p.files.StoredDownloads()
+	require.NoError(t, err)
+	assert.Len(t, stored, 2)
+
+	// disabled cache streams from source
+	p.downloadOptions["tool"] = downloadOptions{}
+	w = serve("/dl/tool/1.0.0/linux-amd64", nil)
+	assert.Equal(t, "v1.0.0", w.Body.String())
+	assert.Equal(t, 4, ds.writes)
+	p.InvalidateCaches()
+	serve("/dl/tool/latest/linux-amd64", nil)
+	assert.Equal(t, 2, ds.latests)
+}
+
+type locatorMock struct {
+	*downloadsMock
+}
+
+func (l locatorMock) DownloadURL(_ context.Context, v util.Version, arch string) (string, error) {
+	return "https://downloads.example.com/tool/" + v.String() + "/" + arch, nil
+}
+
+func Test_DownloadConfig_options(t *testing.T) {
+	cases := []struct {
+		Config   DownloadConfig
+		Expected downloadOptions
+		Error    bool
+	}{
+		{Config: DownloadConfig{}, Expected: downloadOptions{cache: true, latestCacheTTL: DefaultLatestDownloadCacheTTL, redirectTTL: DefaultDownloadRedirectTTL}},
+		{Config: DownloadConfig{Redirect: "signed", RedirectTTL: "1m"}, Expected: downloadOptions{cache: true, latestCacheTTL: DefaultLatestDownloadCacheTTL, redirect: "signed", redirectTTL: time.Minute}},
+		{Config: DownloadConfig{Redirect: "upstream", DisableCache: true}, Expected: downloadOptions{latestCacheTTL: DefaultLatestDownloadCacheTTL, redirect: "upstream", redirectTTL: DefaultDownloadRedirectTTL}},
+		{Config: DownloadConfig{Redirect: "signed", DisableCache: true}, Error: true},
+		{Config: DownloadConfig{Redirect: "unknown"}, Error: true},
+		{Config: DownloadConfig{Architectures: []string{"linux-amd64", "darwin-arm64"}, ArchAliases: map[string]string{"win64": "windows-amd64"}}, Expected: downloadOptions{
+			cache:               true,
+			latestCacheTTL:      DefaultLatestDownloadCacheTTL,
+			redirectTTL:         DefaultDownloadRedirectTTL,
+			architectures:       []string{"darwin-arm64", "linux-amd64"},
+			architectureAliases: map[string]string{"win64": "windows-amd64", "x86_64": "amd64", "x64": "amd64", "aarch64": "arm64", "i386": "386", "i686": "386"},
+		}},
+		{Config: DownloadConfig{Architectures: []string{"linux/amd64"}}, Error: true},
+		{Config: DownloadConfig{ArchAliases: map[string]string{"x86_64": ""}}, Error: true},
+		{Config: DownloadConfig{RedirectTTL: "0s"}, Error: true},
+		{Config: DownloadConfig{LatestCacheTTL: "invalid"}, Error: true},
+	}
+	for i, c := range cases {
+		o, err := c.Config.options()
+		if c.Error {
+			assert.Errorf(t, err, "case %d", i)
+			continue
+		}
+		assert.NoErrorf(t, err, "case %d", i)
+		assert.Equalf(t, c.Expected, o, "case %d", i)
+	}
+}
+
+func Test_GoProxy_serveDownload_redirect(t *testing.T) {
+	ds := &downloadsMock{
+		content: map[string]string{
+			"1.0.0/linux-amd64": "v1.0.0",
+		},
+	}
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		downloads: map[string]source.Downloads{
+			"tool":     locatorMock{ds},
+			"upstream": locatorMock{ds},
+		},
+		downloadOptions: map[string]downloadOptions{
+			"tool":     {cache: true, redirect: redirectSigned, redirectTTL: time.Minute},
+			"upstream": {redirect: redirectUpstream},
+		},
+		downloadsSignKey: []byte("secret"),
+		latestDownloads:  newLatestDownloadCache(),
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(method, path, http.NoBody))
+		return w
+	}
+
+	w := serve(http.MethodGet, "/dl/upstream/1.0.0/linux-amd64")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "https://downloads.example.com/tool/1.0.0/linux-amd64", w.Header().Get("Location"))
+	assert.Equal(t, 0, ds.writes)
+
+	// download is stored before redirect
+	w = serve(http.MethodGet, "/dl/tool/1.0.0/linux-amd64")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Equal(t, 1, ds.writes)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/dl/tool/1.0.0/linux-amd64", location.Path)
+
+	p.auth = &authenticator{}
+	w = serve(http.MethodGet, location.String())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "v1.0.0", w.Body.String())
+	assert.Equal(t, 1, ds.writes)
+
+	// signature is bound to path and expiration
+	query := location.Query()
+	query.Set("expires", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	w = serve(http.MethodGet, location.Path+"?"+query.Encode())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = serve(http.MethodGet, "/dl/upstream/1.0.0/linux-amd64?"+location.RawQuery)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	expired := p.signedDownloadURL("tool", util.Version{Major: 1}, "linux-amd64", -time.Minute)
+	w = serve(http.MethodGet, expired)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+type filesMock struct {
+	*downloadsMock
+	archs []string
+}
+
+func (f filesMock) DownloadArchitectures(context.Context, util.Version) ([]string, error) {
+	f.lookups++
+	return f.archs, nil
+}
+
+// listedFilesMock looks up architectures of listed versions by ID.
+type listedFilesMock struct {
+	filesMock
+	ids []int64
+}
+
+func (f *listedFilesMock) ListedArchitectures(_ context.Context, dv source.DownloadVersion) ([]string, error) {
+	f.ids = append(f.ids, dv.ID)
+	return f.archs, nil
+}
+
+func (f filesMock) WriteDownloadFile(_ context.Context, w http.ResponseWriter, _ *http.Request, v util.Version, arch, suffix string) {
+	_, _ = w.Write([]byte("signature " + v.String() + " " + arch + suffix))
+}
+
+func Test_GoProxy_serveDownload_checksums(t *testing.T) {
+	ds := &downloadsMock{
+		latest: util.Version{Major: 1},
+		content: map[string]string{
+			"1.0.0/linux-amd64":  "amd64",
+			"1.0.0/darwin-arm64": "arm64",
+		},
+	}
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		downloads: map[string]source.Downloads{
+			"tool":   filesMock{downloadsMock: ds, archs: []string{"darwin-arm64", "linux-amd64"}},
+			"stream": ds,
+		},
+		downloadOptions: map[string]downloadOptions{
+			"tool": {cache: true},
+		},
+		latestDownloads:   newLatestDownloadCache(),
+		downloadChecksums: newDownloadChecksumCache(),
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+	amd64 := "5861314d7fccb39c2192173240eab44fa35ca66426201ca2acd0630a6258dd51  tool-1.0.0-linux-amd64\n"
+	arm64 := "f69162950f235e3cdbbad33f1f912d1a504be90d8a37d002c735d6f3e3882265  tool-1.0.0-darwin-arm64\n"
+	cases := []struct {
+		Path   string
+		Status int
+		Body   string
+	}{
+		{Path: "/dl/tool/1.0.0/linux-amd64.sha256", Status: http.StatusOK, Body: amd64},
+		{Path: "/dl/tool/latest/linux-amd64.sha256", Status: http.StatusOK, Body: amd64},
+		{Path: "/dl/tool/1.0.0/SHA256SUMS", Status: http.StatusOK, Body: arm64 + amd64},
+		{Path: "/dl/tool/1.0.0/linux-amd64.sig", Status: http.StatusOK, Body: "signature 1.0.0 linux-amd64.sig"},
+		{Path: "/dl/tool/1.0.0/windows-amd64.sha256", Status: http.StatusNotFound},
+		{Path: "/dl/stream/1.0.0/linux-amd64.sha256", Status: http.StatusOK, Body: strings.Replace(amd64, "tool", "stream", 1)},
+		{Path: "/dl/stream/1.0.0/SHA256SUMS", Status: http.StatusNotFound},
+		{Path: "/dl/stream/1.0.0/linux-amd64.asc", Status: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.Path, http.NoBody))
+		assert.Equalf(t, c.Status, w.Code, "case %d", i)
+		if c.Status == http.StatusOK {
+			assert.Equalf(t, c.Body, w.Body.String(), "case %d", i)
+		}
+	}
+	// stored downloads and checksums of streamed downloads are reused, unknown architecture is not requested
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dl/stream/1.0.0/linux-amd64.sha256", http.NoBody))
+	assert.Equal(t, 3, ds.writes)
+}
+
+func Test_GoProxy_serveDownload_versions(t *testing.T) {
+	published := time.Date(2022, 3, 17, 10, 0, 0, 0, time.UTC)
+	ds := &downloadsMock{
+		versions: []source.DownloadVersion{
+			{Version: util.Version{Major: 1, Minor: 1}, Architectures: []string{"darwin-arm64", "linux-amd64"}, Published: published},
+			{Version: util.Version{Major: 1}, Architectures: []string{"linux-amd64"}, Published: published.Add(-time.Hour)},
+		},
+	}
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		downloads: map[string]source.Downloads{
+			"tool": ds,
+		},
+		downloadOptions: map[string]downloadOptions{
+			"tool": {latestCacheTTL: time.Minute},
+		},
+		latestDownloads: newLatestDownloadCache(),
+	}
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(method, path, http.NoBody))
+		return w
+	}
+
+	w := serve(http.MethodGet, "/dl/tool/versions.json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json; charset=UTF-8", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"versions":[
+		{"version":"1.1.0","architectures":["darwin-arm64","linux-amd64"],"published":"2022-03-17T10:00:00Z"},
+		{"version":"1.0.0","architectures":["linux-amd64"],"published":"2022-03-17T09:00:00Z"}
+	]}`, w.Body.String())
+
+	w = serve(http.MethodGet, "/dl/tool/list")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; charset=UTF-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "1.1.0\n1.0.0\n", w.Body.String())
+	assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+
+	w = serve(http.MethodHead, "/dl/tool/list")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "12", w.Header().Get("Content-Length"))
+	assert.Equal(t, 0, w.Body.Len())
+	assert.Equal(t, 1, ds.lists)
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/dl/unknown/versions.json").Code)
+	p.InvalidateCaches()
+	serve(http.MethodGet, "/dl/tool/versions.json")
+	assert.Equal(t, 2, ds.lists)
+
+	// unknown architectures are looked up only for JSON list
+	files := &downloadsMock{
+		versions: []source.DownloadVersion{
+			{Version: util.Version{Major: 1}, Published: published},
+		},
+	}
+	p.downloads["files"] = filesMock{downloadsMock: files, archs: []string{"linux-amd64"}}
+	p.downloadOptions["files"] = downloadOptions{latestCacheTTL: time.Minute}
+	w = serve(http.MethodGet, "/dl/files/list")
+	assert.Equal(t, "1.0.0\n", w.Body.String())
+	assert.Equal(t, 0, files.lookups)
+	for i := 0; i < 2; i++ {
+		w = serve(http.MethodGet, "/dl/files/versions.json")
+		assert.JSONEq(t, `{"versions":[{"version":"1.0.0","architectures":["linux-amd64"],"published":"2022-03-17T10:00:00Z"}]}`, w.Body.String())
+	}
+	assert.Equal(t, 1, files.lookups)
+	// cached versions are not modified
+	assert.Nil(t, files.versions[0].Architectures)
+
+	// listed versions are not looked up again
+	listed := &listedFilesMock{filesMock: filesMock{
+		downloadsMock: &downloadsMock{
+			versions: []source.DownloadVersion{
+				{Version: util.Version{Major: 1}, Published: published, ID: 42},
+			},
+		},
+		archs: []string{"linux-arm64"},
+	}}
+	p.downloads["listed"] = listed
+	p.downloadOptions["listed"] = downloadOptions{latestCacheTTL: time.Minute}
+	w = serve(http.MethodGet, "/dl/listed/versions.json")
+	assert.JSONEq(t, `{"versions":[{"version":"1.0.0","architectures":["linux-arm64"],"published":"2022-03-17T10:00:00Z"}]}`, w.Body.String())
+	assert.Equal(t, []int64{42}, listed.ids)
+	assert.Equal(t, 0, listed.lookups)
+}
+
+func Test_GoProxy_serveDownload_resolveVersion(t *testing.T) {
+	versions := []source.DownloadVersion(nil)
+	for _, s := range []string{"2.0.0-rc.1", "1.9.2", "1.4.0", "1.10.0-rc.2", "1.10.0-rc.1", "0.9.0"} {
+		v, err := util.ParseVersion(s)
+		require.NoError(t, err)
+		versions = append(versions, source.DownloadVersion{Version: v})
+	}
+	ds := &downloadsMock{
+		latest:   util.Version{Major: 1, Minor: 9, Patch: 2},
+		versions: versions,
+		content: map[string]string{
+			"1.9.2/linux-amd64":      "1.9.2",
+			"2.0.0-rc.1/linux-amd64": "2.0.0-rc.1",
+			"1.4.0/linux-amd64":      "1.4.0",
+		},
+	}
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		downloads: map[string]source.Downloads{
+			"tool": ds,
+		},
+		downloadOptions: map[string]downloadOptions{
+			"tool": {latestCacheTTL: time.Minute},
+		},
+		latestDownloads: newLatestDownloadCache(),
+	}
+	cases := []struct {
+		Version  string
+		Status   int
+		Resolved string
+	}{
+		{Version: "1.4.0", Status: http.StatusOK},
+		{Version: "latest", Status: http.StatusOK, Resolved: "1.9.2"},
+		{Version: "latest-prerelease", Status: http.StatusOK, Resolved: "2.0.0-rc.1"},
+		{Version: "^1.4", Status: http.StatusOK, Resolved: "1.9.2"},
+		{Version: "~1.4", Status: http.StatusOK, Resolved: "1.4.0"},
+		{Version: ">=1.0.0%20<1.5", Status: http.StatusOK, Resolved: "1.4.0"},
+		{Version: "^3", Status: http.StatusNotFound},
+		{Version: "^x", Status: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dl/tool/"+c.Version+"/linux-amd64", http.NoBody))
+		assert.Equalf(t, c.Status, w.Code, "case %d", i)
+		assert.Equalf(t, c.Resolved, w.Header().Get(resolvedVersionHeader), "case %d", i)
+		if c.Resolved != "" {
+			assert.Equalf(t, c.Resolved, w.Body.String(), "case %d", i)
+		}
+	}
+	// versions are listed once
+	assert.Equal(t, 1, ds.lists)
+}
+
+func Test_canonicalArchitecture(t *testing.T) {
+	cases := []struct {
+		Arch      string
+		Canonical string
+	}{
+		{Arch: "linux-amd64", Canonical: "linux-amd64"},
+		{Arch: "linux-x86_64", Canonical: "linux-amd64"},
+		{Arch: "darwin-aarch64", Canonical: "darwin-arm64"},
+		{Arch: "x86_64", Canonical: "amd64"},
+		{Arch: "", Canonical: ""},
+	}
+	for i, c := range cases {
+		assert.Equalf(t, c.Canonical, canonicalArchitecture(defaultArchitectureAliases, c.Arch), "case %d", i)
+	}
+	// architectures named by aliases are matched by canonical architecture
+	arch, ok := matchArchitecture(defaultArchitectureAliases, []string{"Linux-x86_64"}, "Linux-amd64")
+	assert.True(t, ok)
+	assert.Equal(t, "Linux-x86_64", arch)
+}
+
+func Test_GoProxy_serveDownload_architectures(t *testing.T) {
+	ds := &downloadsMock{
+		latest: util.Version{Major: 1},
+		content: map[string]string{
+			"1.0.0/linux-amd64":  "amd64",
+			"1.0.0/darwin-arm64": "arm64",
+			"1.0.0/windows-386":  "386",
+		},
+	}
+	files := &filesMock{downloadsMock: ds, archs: []string{"darwin-arm64", "linux-amd64"}}
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		downloads: map[string]source.Downloads{
+			"tool":     files,
+			"declared": ds,
+			"stream":   ds,
+		},
+		downloadOptions: map[string]downloadOptions{
+			"tool":     {latestCacheTTL: time.Minute},
+			"declared": {architectures: []string{"linux-amd64", "windows-386"}, architectureAliases: map[string]string{"win32": "windows-386"}},
+		},
+		latestDownloads: newLatestDownloadCache(),
+	}
+	cases := []struct {
+		Path   string
+		Status int
+		Body   string
+	}{
+		{Path: "/dl/tool/1.0.0/linux-amd64", Status: http.StatusOK, Body: "amd64"},
+		{Path: "/dl/tool/latest/linux-x86_64", Status: http.StatusOK, Body: "amd64"},
+		{Path: "/dl/tool/1.0.0/darwin-aarch64", Status: http.StatusOK, Body: "arm64"},
+		{Path: "/dl/tool/1.0.0/linux-amd65", Status: http.StatusNotFound, Body: `{"err":"unknown architecture \"linux-amd65\" of tool 1.0.0","architectures":["darwin-arm64","linux-amd64"]}`},
+		{Path: "/dl/tool/1.0.0", Status: http.StatusNotFound, Body: `{"err":"unknown architecture \"\" of tool 1.0.0","architectures":["darwin-arm64","linux-amd64"]}`},
+		{Path: "/dl/declared/1.0.0/win32", Status: http.StatusOK, Body: "386"},
+		{Path: "/dl/declared/1.0.0/darwin-arm64", Status: http.StatusNotFound, Body: `{"err":"unknown architecture \"darwin-arm64\" of declared 1.0.0","architectures":["linux-amd64","windows-386"]}`},
+		{Path: "/dl/stream/1.0.0/linux-x86_64", Status: http.StatusOK, Body: "amd64"},
+		{Path: "/dl/stream/1.0.0/linux-arm64", Status: http.StatusNotFound, Body: ""},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.Path, http.NoBody))
+		assert.Equalf(t, c.Status, w.Code, "case %d", i)
+		if strings.HasPrefix(c.Body, "{") {
+			assert.JSONEqf(t, c.Body, w.Body.String(), "case %d", i)
+		} else {
+			assert.Equalf(t, c.Body, w.Body.String(), "case %d", i)
+		}
+	}
+
+	// cached architectures are refreshed by request of unknown architecture
+	files.archs = append(files.archs, "windows-386")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dl/tool/1.0.0/windows-386", http.NoBody))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "386", w.Body.String())
+}
+
+// truncatedMock writes only a part of download announced by Content-Length, optionally reporting the failure.
+type truncatedMock struct {
+	*downloadsMock
+	abort bool
+}
+
+func (d truncatedMock) WriteDownload(_ context.Context, w http.ResponseWriter, _ *http.Request, _ util.Version, _ string) {
+	d.writes++
+	w.Header().Set("Content-Length", "10")
+	_, _ = w.Write([]byte("01234"))
+	if d.abort {
+		source.AbortDownload(w, io.ErrUnexpectedEOF)
+	}
+}
+
+func Test_GoProxy_serveDownload_truncated(t *testing.T) {
+	ds := &downloadsMock{}
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		downloads: map[string]source.Downloads{
+			"tool":   truncatedMock{downloadsMock: ds},
+			"abort":  truncatedMock{downloadsMock: ds, abort: true},
+			"stream": truncatedMock{downloadsMock: ds},
+		},
+		downloadOptions: map[string]downloadOptions{
+			"tool":  {cache: true},
+			"abort": {cache: true},
+		},
+		latestDownloads:   newLatestDownloadCache(),
+		downloadChecksums: newDownloadChecksumCache(),
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+	// response streamed while the download is stored is aborted
+	for i, path := range []string{
+		"/dl/tool/1.0.0/linux-amd64",
+		"/dl/abort/1.0.0/linux-amd64",
+	} {
+		w := httptest.NewRecorder()
+		assert.PanicsWithValuef(t, http.ErrAbortHandler, func() {
+			p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+		}, "case %d", i)
+		assert.Equalf(t, "01234", w.Body.String(), "case %d", i)
+	}
+	for i, path := range []string{
+		"/dl/tool/1.0.0/linux-amd64",
+		"/dl/tool/1.0.0/linux-amd64.sha256",
+		"/dl/stream/1.0.0/linux-amd64.sha256",
+		"/dl/stream/1.0.0/linux-amd64.sha256",
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		if i == 0 {
+			// range request is served after the download is stored
+			req.Header.Set("Range", "bytes=0-1")
+		}
+		p.ServeHTTP(w, req)
+		assert.Equalf(t, http.StatusInternalServerError, w.Code, "case %d", i)
+	}
+	// incomplete downloads and their checksums are neither stored nor cached
+	stored, err := p.files.StoredDownloads()
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+	assert.Equal(t, 6, ds.writes)
+
+	err = p.files.StoreDownload("abort", "1.0.0", "", func(w io.Writer) (storage.DownloadMeta, error) {
+		return recordDownload(context.Background(), truncatedMock{downloadsMock: ds, abort: true}, httptest.NewRequest(http.MethodGet, "/", http.NoBody), util.Version{Major: 1}, "", w)
+	})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+// slowMock writes the first part of download and waits for proceed before the rest.
+type slowMock struct {
+	*downloadsMock
+	proceed chan struct{}
+}
+
+func (d slowMock) WriteDownload(_ context.Context, w http.ResponseWriter, _ *http.Request, _ util.Version, _ string) {
+	w.Header().Set("Content-Length", "10")
+	_, _ = w.Write([]byte("01234"))
+	<-d.proceed
+	_, _ = w.Write([]byte("56789"))
+}
+
+// notifyRecorder reports the first write of response body.
+type notifyRecorder struct {
+	*httptest.ResponseRecorder
+	written chan struct{}
+}
+
+func (r *notifyRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseRecorder.Write(b)
+	if r.written != nil {
+		close(r.written)
+		r.written = nil
+	}
+	return n, err
+}
+
+func Test_GoProxy_serveDownload_streamWhileStored(t *testing.T) {
+	ds := slowMock{downloadsMock: &downloadsMock{}, proceed: make(chan struct{})}
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		downloads: map[string]source.Downloads{
+			"tool": ds,
+		},
+		downloadOptions: map[string]downloadOptions{
+			"tool": {cache: true},
+		},
+		latestDownloads: newLatestDownloadCache(),
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+	written := make(chan struct{})
+	w := &notifyRecorder{ResponseRecorder: httptest.NewRecorder(), written: written}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dl/tool/1.0.0/linux-amd64", http.NoBody))
+	}()
+	// the client receives the first part before the download is stored
+	<-written
+	stored, err := p.files.StoredDownloads()
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.True(t, stored[0].Locked)
+	close(ds.proceed)
+	<-done
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+	assert.Equal(t, "10", w.Header().Get("Content-Length"))
+	assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+	stored, err = p.files.StoredDownloads()
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.False(t, stored[0].Locked)
+	assert.Equal(t, int64(10), stored[0].Size)
+}
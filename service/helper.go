@@ -5,7 +5,10 @@
 package service
 
 import (
+	"context"
 	"net/http"
+
+	"go.lstv.dev/goproxy/logger"
 )
 
 var contentTypes = map[string]string{
@@ -19,3 +22,16 @@ var contentTypes = map[string]string{
 func setContentType(w http.ResponseWriter, key string) {
 	w.Header().Set("Content-Type", contentTypes[key])
 }
+
+// requestContext returns context of request with logger fields request_id and trace_id (if tracing is enabled).
+func requestContext(req *http.Request, requestID string) context.Context {
+	ctx := logger.ContextWith(req.Context(),
+		"request_id", requestID,
+	)
+	if traceID := traceID(ctx); traceID != "" {
+		ctx = logger.ContextWith(ctx,
+			"trace_id", traceID,
+		)
+	}
+	return ctx
+}
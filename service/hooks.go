@@ -0,0 +1,146 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/util"
+)
+
+const (
+	gitLabTokenHeader      = "X-Gitlab-Token"
+	gitLabTagPushEventKind = "tag_push"
+	gitLabTagRefPrefix     = "refs/tags/"
+	gitLabZeroCommit       = "0000000000000000000000000000000000000000"
+
+	maxWebhookBodySize = 10 << 20 // 10 MiB
+)
+
+type gitLabTagPushEvent struct {
+	ObjectKind string `json:"object_kind"`
+	After      string `json:"after"`
+	Ref        string `json:"ref"`
+	ProjectID  int64  `json:"project_id"`
+}
+
+// GitLabHook handles GitLab Tag Push webhooks.
+// Caches of matching modules are invalidated and pushed versions are downloaded in the background.
+func (p *GoProxy) GitLabHook(w http.ResponseWriter, req *http.Request) {
+	requestID := requestIDOf(req)
+	ctx := requestContext(req, requestID)
+	log := p.log.Ctx(ctx).With(
+		"func", "GitLabHook",
+	)
+
+	if p.webhooks.GitLab == nil {
+		log.Debug("gitlab webhook is not configured")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if req.Method != http.MethodPost {
+		log.Debug("expected POST method")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token := req.Header.Get(gitLabTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.webhooks.GitLab.SecretToken)) != 1 {
+		log.Debug("invalid webhook token")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	event := gitLabTagPushEvent{}
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxWebhookBodySize)).Decode(&event); err != nil {
+		log.Err(err).Debug("invalid webhook payload")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if event.ObjectKind != gitLabTagPushEventKind || !strings.HasPrefix(event.Ref, gitLabTagRefPrefix) {
+		log.With(
+			"object_kind", event.ObjectKind,
+			"ref", event.Ref,
+		).Debug("ignored webhook event")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	tag := event.Ref[len(gitLabTagRefPrefix):]
+	deleted := event.After == gitLabZeroCommit
+	log = log.With(
+		"project_id", event.ProjectID,
+		"tag", tag,
+		"deleted", deleted,
+	)
+	result := struct {
+		Modules map[string]string `json:"modules"`
+	}{
+		Modules: map[string]string{},
+	}
+	for module, s := range p.modules {
+		m, ok := s.(source.TagMatcher)
+		if !ok {
+			continue
+		}
+		version, ok := m.MatchTag(event.ProjectID, tag)
+		if !ok {
+			continue
+		}
+		if c, ok := s.(source.CacheInvalidator); ok {
+			c.InvalidateCache()
+		}
+		if v, err := util.ParseTagVersion(version); err == nil {
+			module = util.SetVersionSuffix(module, v.Major)
+		}
+		result.Modules[module] = version
+		log.With(
+			"module", module,
+			"version", version,
+		).Info("matched webhook tag")
+		if deleted {
+			continue
+		}
+		bgCtx := logger.ContextWith(p.tasks.context(),
+			"request_id", requestID,
+			"module", module,
+			"version", version,
+		)
+		p.tasks.add()
+		go func(s source.Source, module, version string) {
+			defer p.tasks.done()
+			if _, err := p.storeVersion(bgCtx, module, version, s); err != nil {
+				p.log.Ctx(bgCtx).Err(err).Warn("unable to prefetch module")
+				return
+			}
+			p.log.Ctx(bgCtx).Debug("module prefetched")
+		}(s, module, version)
+	}
+
+	setContentType(w, "json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Err(err).Warn("webhook result encoding failed")
+	}
+}
+
+// storeVersion downloads module at specified version into storage unless it is already stored.
+func (p *GoProxy) storeVersion(ctx context.Context, module, version string, s source.Source) (downloaded bool, err error) {
+	if ok, err := p.files.HasVersion(module, version); ok {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := p.downloadModule(ctx, s, p.files.Chroot, version); err != nil {
+		return false, err
+	}
+	return true, nil
+}
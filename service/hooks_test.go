@@ -0,0 +1,136 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sourceMock struct {
+	source.Source
+	projectID   int64
+	tagPrefix   string
+	versions    map[uint][]string
+	invalidated bool
+	downloaded  chan string
+}
+
+func (s *sourceMock) MatchTag(projectID int64, tag string) (string, bool) {
+	if projectID != s.projectID || !strings.HasPrefix(tag, s.tagPrefix) {
+		return "", false
+	}
+	return tag[len(s.tagPrefix):], true
+}
+
+func (s *sourceMock) ListVersions(_ context.Context, major uint) ([]string, error) {
+	return s.versions[major], nil
+}
+
+func (s *sourceMock) InvalidateCache() {
+	s.invalidated = true
+}
+
+func (s *sourceMock) DownloadModule(_ context.Context, _, version string) error {
+	s.downloaded <- version
+	return nil
+}
+
+func newHookTestProxy(t *testing.T, modules map[string]source.Source) *GoProxy {
+	return &GoProxy{
+		log: logger.Type("service.GoProxy"),
+		webhooks: WebhooksConfig{
+			GitLab: &GitLabWebhookConfig{
+				SecretToken: "secret",
+			},
+		},
+		modules: modules,
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+}
+
+func Test_GoProxy_GitLabHook(t *testing.T) {
+	a := &sourceMock{projectID: 1, tagPrefix: "a-", downloaded: make(chan string, 1)}
+	b := &sourceMock{projectID: 1, tagPrefix: "b-", downloaded: make(chan string, 1)}
+	p := newHookTestProxy(t, map[string]source.Source{
+		"example.com/a": a,
+		"example.com/b": b,
+		"example.com":   nil,
+	})
+
+	body := `{"object_kind":"tag_push","after":"8a2e","ref":"refs/tags/a-v2.1.0","project_id":1}`
+	req := httptest.NewRequest(http.MethodPost, "/hooks/gitlab", strings.NewReader(body))
+	req.Header.Set("X-Gitlab-Token", "secret")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.JSONEq(t, `{"modules":{"example.com/a/v2":"v2.1.0"}}`, w.Body.String())
+	select {
+	case version := <-a.downloaded:
+		assert.Equal(t, "v2.1.0", version)
+	case <-time.After(time.Second):
+		t.Fatal("module was not downloaded")
+	}
+	assert.True(t, a.invalidated)
+	assert.False(t, b.invalidated)
+}
+
+func Test_GoProxy_GitLabHook_deletedTag(t *testing.T) {
+	a := &sourceMock{projectID: 1, tagPrefix: "a-", downloaded: make(chan string, 1)}
+	p := newHookTestProxy(t, map[string]source.Source{
+		"example.com/a": a,
+	})
+
+	body := `{"object_kind":"tag_push","after":"0000000000000000000000000000000000000000","ref":"refs/tags/a-v1.0.0","project_id":1}`
+	req := httptest.NewRequest(http.MethodPost, "/hooks/gitlab", strings.NewReader(body))
+	req.Header.Set("X-Gitlab-Token", "secret")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.True(t, a.invalidated)
+	assert.Empty(t, a.downloaded)
+}
+
+func Test_GoProxy_GitLabHook_rejected(t *testing.T) {
+	p := newHookTestProxy(t, map[string]source.Source{})
+	cases := []struct {
+		method string
+		token  string
+		body   string
+		status int
+	}{
+		{method: http.MethodGet, token: "secret", body: "", status: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, token: "", body: "{}", status: http.StatusUnauthorized},
+		{method: http.MethodPost, token: "invalid", body: "{}", status: http.StatusUnauthorized},
+		{method: http.MethodPost, token: "secret", body: "{", status: http.StatusBadRequest},
+		{method: http.MethodPost, token: "secret", body: `{"object_kind":"push"}`, status: http.StatusNoContent},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(c.method, "/hooks/gitlab", strings.NewReader(c.body))
+		req.Header.Set("X-Gitlab-Token", c.token)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, c.status, w.Code, "case %d", i)
+	}
+
+	p.webhooks.GitLab = nil
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/hooks/gitlab", http.NoBody))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
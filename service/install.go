@@ -0,0 +1,222 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"go.lstv.dev/goproxy/source"
+)
+
+const (
+	// installScript is name of generated install script of download.
+	installScript = "install.sh"
+	// DefaultInstallDir is default target directory of install scripts.
+	DefaultInstallDir = "/usr/local/bin"
+)
+
+var installTemplate = template.Must(template.New("install").Funcs(template.FuncMap{
+	"quote": shellQuote,
+}).Parse(installTemplateContent))
+
+// shellQuote quotes value as single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// baseURL returns configured external URL of the proxy, or scheme and host of the request.
+// Forwarded headers are not trusted, external_url must be configured behind a reverse proxy.
+func (p *GoProxy) baseURL(req *http.Request) string {
+	if p.externalURL != "" {
+		return p.externalURL
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
+
+// installArchitecture is a branch of architecture detection in install script.
+type installArchitecture struct {
+	Arch    string
+	Aliases []string // names reported by uname -m
+}
+
+// installArchitectures returns branches of architecture detection from aliases, only aliases of the whole
+// architecture without operating system are used. Other names reported by uname -m are resolved by the proxy.
+func installArchitectures(aliases map[string]string) []installArchitecture {
+	byArch := map[string][]string{}
+	for alias, arch := range aliases {
+		if strings.Contains(alias, "-") || strings.Contains(arch, "-") {
+			continue
+		}
+		byArch[arch] = append(byArch[arch], alias)
+	}
+	result := make([]installArchitecture, 0, len(byArch))
+	for arch, names := range byArch {
+		sort.Strings(names)
+		result = append(result, installArchitecture{
+			Arch:    arch,
+			Aliases: names,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Arch < result[j].Arch
+	})
+	return result
+}
+
+// serveInstallScript serves POSIX shell script installing download of version from query (latest by default)
+// to directory from query.
+func (p *GoProxy) serveInstallScript(ctx context.Context, w http.ResponseWriter, req *http.Request, name string, ds source.Downloads) {
+	log := p.log.Ctx(ctx).With(
+		"func", "serveInstallScript",
+	)
+	query := req.URL.Query()
+	version := query.Get("version")
+	if version == "" {
+		version = "latest"
+	}
+	v, _, err := p.resolveDownloadVersion(ctx, name, ds, version)
+	if err != nil {
+		log.Err(err).Debug("invalid download version")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	dir := query.Get("dir")
+	if dir == "" {
+		dir = DefaultInstallDir
+	}
+	disableArchitecture, fileExtension := false, ""
+	if l, ok := ds.(source.DownloadLayout); ok {
+		disableArchitecture, fileExtension = l.DownloadLayout()
+	}
+	aliases := p.downloadOptions[name].architectureAliases
+	if aliases == nil {
+		aliases = defaultArchitectureAliases
+	}
+	buf := &bytes.Buffer{}
+	err = installTemplate.Execute(buf, map[string]any{
+		"Name":                name,
+		"Version":             v.String(),
+		"URL":                 p.baseURL(req) + p.downloadsPathPrefix + "/" + name + "/" + v.String(),
+		"Dir":                 dir,
+		"DisableArchitecture": disableArchitecture,
+		"Architectures":       installArchitectures(aliases),
+		"FileExtension":       fileExtension,
+	})
+	if err != nil {
+		log.Err(err).Warn("install script rendering failed")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	p.setCacheControl(w, mutableMaxAge, false)
+	w.Header().Set(resolvedVersionHeader, v.String())
+	w.Header().Set("Content-Type", "text/x-shellscript; charset=UTF-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Err(err).Debug("unable to write install script")
+	}
+}
+
+const installTemplateContent = `#!/bin/sh
+# Install script of {{ .Name }} {{ .Version }} generated by goproxy.
+# Target directory can be changed by INSTALL_DIR environment variable.
+set -eu
+
+NAME={{ quote .Name }}
+VERSION={{ quote .Version }}
+URL={{ quote .URL }}
+FILE_EXTENSION={{ quote .FileExtension }}
+DEFAULT_INSTALL_DIR={{ quote .Dir }}
+INSTALL_DIR="${INSTALL_DIR:-$DEFAULT_INSTALL_DIR}"
+
+fail() {
+	echo "install: $*" >&2
+	exit 1
+}
+
+fetch() {
+	if command -v curl >/dev/null 2>&1; then
+		curl -fsSL --netrc-optional -o "$2" "$1"
+	elif command -v wget >/dev/null 2>&1; then
+		wget -q -O "$2" "$1"
+	else
+		fail "curl or wget is required"
+	fi
+}
+
+sha256() {
+	if command -v sha256sum >/dev/null 2>&1; then
+		sha256sum "$1" | cut -d ' ' -f 1
+	elif command -v shasum >/dev/null 2>&1; then
+		shasum -a 256 "$1" | cut -d ' ' -f 1
+	else
+		fail "sha256sum or shasum is required"
+	fi
+}
+{{ if not .DisableArchitecture }}
+os=$(uname -s | tr '[:upper:]' '[:lower:]')
+case "$os" in
+	linux | darwin | freebsd) ;;
+	mingw* | msys* | cygwin*) os=windows ;;
+	*) fail "unsupported operating system $os" ;;
+esac
+# other architectures are passed to the proxy as reported by uname
+arch=$(uname -m)
+case "$arch" in
+{{- range .Architectures }}
+	{{ range $i, $alias := .Aliases }}{{ if $i }} | {{ end }}{{ quote $alias }}{{ end }}) arch={{ quote .Arch }} ;;
+{{- end }}
+	armv6* | armv7*) arch=arm ;;
+esac
+URL="$URL/$os-$arch"
+{{ end }}
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+file="$tmp/download$FILE_EXTENSION"
+
+echo "downloading $NAME $VERSION from $URL"
+fetch "$URL" "$file"
+fetch "$URL.sha256" "$tmp/checksum"
+expected=$(cut -d ' ' -f 1 "$tmp/checksum")
+actual=$(sha256 "$file")
+[ "$expected" = "$actual" ] || fail "checksum mismatch: expected $expected, got $actual"
+
+case "$FILE_EXTENSION" in
+	*.tar.gz | *.tgz)
+		mkdir "$tmp/extract"
+		tar -xzf "$file" -C "$tmp/extract"
+		;;
+	*.zip)
+		mkdir "$tmp/extract"
+		unzip -q "$file" -d "$tmp/extract"
+		;;
+esac
+binary="$file"
+if [ -d "$tmp/extract" ]; then
+	binary=$(find "$tmp/extract" -type f \( -name "$NAME" -o -name "$NAME.exe" \) | head -n 1)
+	[ -n "$binary" ] || fail "$NAME not found in $URL"
+fi
+target="$INSTALL_DIR/$NAME"
+case "$binary" in
+	*.exe) target="$target.exe" ;;
+esac
+
+mkdir -p "$INSTALL_DIR"
+cp "$binary" "$target"
+chmod 0755 "$target"
+echo "installed $NAME $VERSION to $target"
+`
@@ -0,0 +1,144 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+	"go.lstv.dev/goproxy/util"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type layoutMock struct {
+	*downloadsMock
+	disableArchitecture bool
+	fileExtension       string
+}
+
+func (l layoutMock) DownloadLayout() (bool, string) {
+	return l.disableArchitecture, l.fileExtension
+}
+
+func Test_shellQuote(t *testing.T) {
+	assert.Equal(t, `'/usr/local/bin'`, shellQuote("/usr/local/bin"))
+	assert.Equal(t, `'it'\''s $HOME'`, shellQuote("it's $HOME"))
+}
+
+func Test_GoProxy_serveInstallScript(t *testing.T) {
+	ds := &downloadsMock{
+		latest: util.Version{Major: 1, Minor: 1},
+		content: map[string]string{
+			"1.1.0/": "#!/bin/sh\necho tool 1.1.0\n",
+		},
+	}
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		downloads: map[string]source.Downloads{
+			"tool":  layoutMock{downloadsMock: ds, disableArchitecture: true},
+			"archs": layoutMock{downloadsMock: ds, fileExtension: ".tar.gz"},
+		},
+		downloadOptions: map[string]downloadOptions{
+			"tool":  {cache: true, latestCacheTTL: time.Minute},
+			"archs": {latestCacheTTL: time.Minute},
+		},
+		latestDownloads:   newLatestDownloadCache(),
+		downloadChecksums: newDownloadChecksumCache(),
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+	server := httptest.NewServer(p)
+	t.Cleanup(server.Close)
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(b)
+	}
+
+	resp, script := get("/dl/archs/install.sh?dir=/opt/it's")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1.1.0", resp.Header.Get(resolvedVersionHeader))
+	assert.Contains(t, script, "URL='"+server.URL+"/dl/archs/1.1.0'\n")
+	assert.Contains(t, script, "FILE_EXTENSION='.tar.gz'\n")
+	assert.Contains(t, script, `DEFAULT_INSTALL_DIR='/opt/it'\''s'`)
+	assert.Contains(t, script, `URL="$URL/$os-$arch"`)
+	assert.Contains(t, script, "\t'x64' | 'x86_64') arch='amd64' ;;\n")
+	assert.Contains(t, script, "\t'aarch64') arch='arm64' ;;\n")
+	archsScript := script
+
+	// forwarded headers are not trusted without external_url
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/dl/archs/install.sh", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "evil.example.com")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Contains(t, string(b), "URL='"+server.URL+"/dl/archs/1.1.0'\n")
+	p.externalURL = "https://goproxy.example.com"
+	_, script = get("/dl/archs/install.sh")
+	assert.Contains(t, script, "URL='https://goproxy.example.com/dl/archs/1.1.0'\n")
+	p.externalURL = ""
+
+	resp, _ = get("/dl/archs/install.sh?version=^3")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, script = get("/dl/tool/install.sh")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, script, "uname")
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+	require.NoError(t, exec.Command(sh, "-n", "-c", archsScript).Run())
+	require.NoError(t, exec.Command(sh, "-n", "-c", script).Run())
+	_, curlErr := exec.LookPath("curl")
+	_, wgetErr := exec.LookPath("wget")
+	if curlErr != nil && wgetErr != nil {
+		t.Skip("curl or wget is not available")
+	}
+	dir := t.TempDir()
+	cmd := exec.Command(sh, "-c", script)
+	cmd.Env = append(os.Environ(), "INSTALL_DIR="+dir)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	b, err = os.ReadFile(filepath.Join(dir, "tool"))
+	require.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\necho tool 1.1.0\n", string(b))
+}
+
+func Test_installArchitectures(t *testing.T) {
+	assert.Equal(t, []installArchitecture{
+		{Arch: "386", Aliases: []string{"i386", "i686"}},
+		{Arch: "amd64", Aliases: []string{"x64", "x86_64"}},
+		{Arch: "arm64", Aliases: []string{"aarch64"}},
+	}, installArchitectures(defaultArchitectureAliases))
+	// aliases including operating system are resolved by the proxy
+	assert.Equal(t, []installArchitecture{
+		{Arch: "arm", Aliases: []string{"armv7l"}},
+	}, installArchitectures(map[string]string{"armv7l": "arm", "win64": "windows-amd64", "linux-x64": "linux-amd64"}))
+}
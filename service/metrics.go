@@ -0,0 +1,230 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/util"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsPath = "/metrics"
+
+	// storageMetricsInterval is minimal interval between walks of storage for storage gauges.
+	storageMetricsInterval = time.Minute
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goproxy_requests_total",
+		Help: "Count of handled requests by action and status code.",
+	}, []string{"action", "code"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goproxy_request_duration_seconds",
+		Help:    "Duration of handled requests by action.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"action"})
+	storageLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goproxy_storage_lookups_total",
+		Help: "Lookups of module versions in storage by client requests by result (hit, miss).",
+	}, []string{"result"})
+	downloadsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "goproxy_downloads_in_flight",
+		Help: "Downloads in progress by kind (module, download).",
+	}, []string{"kind"})
+	storageModules = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "goproxy_storage_modules",
+		Help: "Count of stored modules.",
+	})
+	storageVersions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "goproxy_storage_versions",
+		Help: "Count of stored module versions.",
+	})
+	storageBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "goproxy_storage_bytes",
+		Help: "Total size of stored modules.",
+	})
+	buildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "goproxy_build_info",
+		Help: "Build information, the value is always 1.",
+	}, []string{"version"})
+
+	metricsHandler = promhttp.Handler()
+)
+
+func init() {
+	buildInfo.WithLabelValues(util.BuiltinVersion()).Set(1)
+}
+
+// statusWriter records status code and size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom delegates to the original writer, so sendfile is used for stored files.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the original writer for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// requestAction returns action label of request path for metrics.
+func (p *GoProxy) requestAction(path string) string {
+	switch path {
+	case "/":
+		return "index"
+	case "/versions.json":
+		return "versions"
+	case "/healthz", "/favicon.ico", metricsPath:
+		return "internal"
+	case "/hooks/gitlab":
+		return "webhook"
+	}
+	switch {
+	case strings.HasPrefix(path, adminPathPrefix+"/"):
+		return "admin"
+	case strings.HasPrefix(path, p.downloadsPathPrefix):
+		return "download"
+	}
+	module, version, action, err := util.ParseURL(path)
+	if err != nil {
+		return "redirect"
+	}
+	if _, ok := p.modules[util.RemoveVersionSuffix(module)]; !ok {
+		return "redirect"
+	}
+	if version == "latest" {
+		return "latest"
+	}
+	return action
+}
+
+func observeRequest(action string, status int, start time.Time) {
+	requestsTotal.WithLabelValues(action, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(action).Observe(time.Since(start).Seconds())
+}
+
+// downloadModule downloads module from the source with tracking of downloads in progress.
+func (p *GoProxy) downloadModule(ctx context.Context, s source.Source, dir, version string) error {
+	g := downloadsInFlight.WithLabelValues("module")
+	g.Inc()
+	defer g.Dec()
+	return s.DownloadModule(ctx, dir, version)
+}
+
+// hasVersion checks that the version is stored and counts storage hits and misses,
+// it is used only for client requests.
+func (p *GoProxy) hasVersion(module, version string) (bool, error) {
+	ok, err := p.files.HasVersion(module, version)
+	if err == nil {
+		if ok {
+			storageLookupsTotal.WithLabelValues("hit").Inc()
+		} else {
+			storageLookupsTotal.WithLabelValues("miss").Inc()
+		}
+	}
+	return ok, err
+}
+
+// storageMetrics limits walks of storage for storage gauges.
+type storageMetrics struct {
+	mutex   sync.Mutex
+	updated time.Time
+}
+
+// update sets storage gauges unless they were set within storageMetricsInterval.
+func (m *storageMetrics) update(p *GoProxy) {
+	if m != nil {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if time.Since(m.updated) < storageMetricsInterval {
+			return
+		}
+	}
+	stored, err := p.files.StoredModules()
+	if err != nil {
+		p.log.Err(err).Warn("unable to get stored modules for metrics")
+		return
+	}
+	versions, size := 0, int64(0)
+	for _, m := range stored {
+		versions += len(m.Versions)
+		size += m.TotalSize
+	}
+	storageModules.Set(float64(len(stored)))
+	storageVersions.Set(float64(versions))
+	storageBytes.Set(float64(size))
+	if m != nil {
+		m.updated = time.Now()
+	}
+}
+
+// serveMetrics writes metrics in Prometheus text format to admin only.
+func (p *GoProxy) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	if !p.AdminEnabled() {
+		p.log.Debug("admin api is not configured, metrics are disabled")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !p.isAdmin(req) {
+		p.log.Debug("invalid admin token for metrics")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="goproxy admin"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	p.storageMetrics.update(p)
+	metricsHandler.ServeHTTP(w, req)
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GoProxy_requestAction(t *testing.T) {
+	p := &GoProxy{
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		modules: map[string]source.Source{
+			"example.com/a": nil,
+		},
+	}
+	cases := map[string]string{
+		"/":                                 "index",
+		"/versions.json":                    "versions",
+		"/metrics":                          "internal",
+		"/hooks/gitlab":                     "webhook",
+		"/admin/caches":                     "admin",
+		"/dl/tool/latest/linux-amd64":       "download",
+		"/example.com/a/@v/list":            "list",
+		"/example.com/a/v2/@v/v2.0.0.info":  "info",
+		"/example.com/a/@v/v1.0.0.mod":      "mod",
+		"/example.com/a/@v/v1.0.0.zip":      "zip",
+		"/example.com/a/@latest":            "latest",
+		"/example.com/other/@v/v1.0.0.info": "redirect",
+		"/unknown":                          "redirect",
+	}
+	for path, expected := range cases {
+		assert.Equal(t, expected, p.requestAction(path), path)
+	}
+}
+
+func Test_GoProxy_serveMetrics(t *testing.T) {
+	dir := t.TempDir()
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		admin:               AdminConfig{Token: "secret"},
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		modules: map[string]source.Source{
+			"example.com/a": nil,
+		},
+		storageMetrics: &storageMetrics{},
+		files: storage.Dir{
+			Chroot: dir,
+		},
+	}
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/example.com/a/@v/list", http.NoBody))
+
+	for i, c := range []struct {
+		token  string
+		status int
+	}{
+		{status: http.StatusUnauthorized},
+		{token: "invalid", status: http.StatusUnauthorized},
+		{token: "secret", status: http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, c.status, w.Code, "case %d", i)
+		if c.status != http.StatusOK {
+			assert.NotContains(t, w.Body.String(), "goproxy_", "case %d", i)
+			continue
+		}
+		assert.Contains(t, w.Body.String(), `goproxy_requests_total{action="list",code="404"} `, "case %d", i)
+		assert.Contains(t, w.Body.String(), `goproxy_request_duration_seconds_count{action="list"} `, "case %d", i)
+		assert.Contains(t, w.Body.String(), "goproxy_storage_modules 0\n", "case %d", i)
+		assert.Contains(t, w.Body.String(), "# TYPE goproxy_build_info gauge\n", "case %d", i)
+	}
+
+	// storage is not walked on every scrape
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "example.com/a"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com/a/v1.0.0.info"), []byte(`{}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com/a/v1.0.0.zip"), []byte("0123456789"), 0644))
+	req := httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "goproxy_storage_modules 0\n")
+	p.storageMetrics.updated = time.Time{}
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "goproxy_storage_modules 1\n")
+	assert.Contains(t, w.Body.String(), "goproxy_storage_bytes 10\n")
+
+	// metrics are disabled without admin token
+	p.admin = AdminConfig{}
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_GoProxy_storeVersion_lookups(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "example.com/a"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com/a/v1.0.0.info"), []byte(`{}`), 0644))
+	p := &GoProxy{
+		log: logger.Type("service.GoProxy"),
+		files: storage.Dir{
+			Chroot: dir,
+		},
+	}
+	hits := testutil.ToFloat64(storageLookupsTotal.WithLabelValues("hit"))
+	// prefetch and webhooks are not counted as storage lookups
+	downloaded, err := p.storeVersion(context.Background(), "example.com/a", "v1.0.0", nil)
+	assert.NoError(t, err)
+	assert.False(t, downloaded)
+	assert.Equal(t, hits, testutil.ToFloat64(storageLookupsTotal.WithLabelValues("hit")))
+
+	ok, err := p.hasVersion("example.com/a", "v1.0.0")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, hits+1, testutil.ToFloat64(storageLookupsTotal.WithLabelValues("hit")))
+}
+
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func Test_statusWriter_ReadFrom(t *testing.T) {
+	rec := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	w := &statusWriter{ResponseWriter: rec}
+	// http.ServeContent copies limited reader
+	n, err := io.Copy(w, io.LimitReader(strings.NewReader("content"), 7))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), n)
+	assert.True(t, rec.readFrom)
+	assert.Equal(t, int64(7), w.bytes)
+	assert.Equal(t, http.StatusOK, w.statusCode())
+	assert.Equal(t, "content", rec.Body.String())
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/util"
+)
+
+const DefaultPrefetchConcurrency = 4
+
+type PrefetchOptions struct {
+	// Latest is count of the latest versions per major to download, 0 means all versions.
+	Latest int
+	// Concurrency is count of parallel downloads, DefaultPrefetchConcurrency is used if not positive.
+	Concurrency int
+}
+
+type PrefetchResult struct {
+	Stored     int               `json:"stored"`
+	Downloaded int               `json:"downloaded"`
+	Failed     []PrefetchFailure `json:"failed"`
+}
+
+type PrefetchFailure struct {
+	Module  string `json:"module"`
+	Version string `json:"version,omitempty"`
+	Err     string `json:"err"`
+}
+
+type prefetchJob struct {
+	module  string
+	version string
+	source  source.Source
+}
+
+// Prefetch downloads versions of all configured modules into storage.
+func (p *GoProxy) Prefetch(ctx context.Context, options PrefetchOptions) PrefetchResult {
+	log := p.log.Ctx(ctx).With(
+		"func", "Prefetch",
+		"latest", options.Latest,
+	)
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultPrefetchConcurrency
+	}
+
+	result := PrefetchResult{}
+	mutex := sync.Mutex{}
+	jobs := make(chan prefetchJob)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				c := logger.ContextWith(ctx,
+					"module", job.module,
+					"version", job.version,
+				)
+				downloaded, err := p.storeVersion(c, job.module, job.version, job.source)
+				mutex.Lock()
+				switch {
+				case err != nil:
+					log.Ctx(c).Err(err).Warn("unable to prefetch module")
+					result.Failed = append(result.Failed, PrefetchFailure{
+						Module:  job.module,
+						Version: job.version,
+						Err:     err.Error(),
+					})
+				case downloaded:
+					log.Ctx(c).Debug("module prefetched")
+					result.Downloaded++
+				default:
+					result.Stored++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range p.ModuleNames() {
+		s := p.modules[name]
+		if s == nil {
+			continue
+		}
+		for major := uint(1); ; major++ {
+			module := util.SetVersionSuffix(name, major)
+			versions, err := s.ListVersions(ctx, major)
+			if err != nil {
+				log.Err(err).With(
+					"module", module,
+				).Warn("unable to list module versions")
+				mutex.Lock()
+				result.Failed = append(result.Failed, PrefetchFailure{
+					Module: module,
+					Err:    err.Error(),
+				})
+				mutex.Unlock()
+				break
+			}
+			if len(versions) == 0 && major > 1 {
+				break
+			}
+			for _, version := range latestVersions(versions, options.Latest) {
+				select {
+				case jobs <- prefetchJob{module: module, version: version, source: s}:
+				case <-ctx.Done():
+				}
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	log.With(
+		"stored", result.Stored,
+		"downloaded", result.Downloaded,
+		"failed", len(result.Failed),
+	).Info("prefetch finished")
+	return result
+}
+
+// latestVersions returns count of the latest tag versions, all versions are returned for count 0.
+func latestVersions(versions []string, count int) []string {
+	sorted := make([]string, 0, len(versions))
+	for _, v := range versions {
+		if _, err := util.ParseTagVersion(v); err == nil {
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		c, _ := util.CompareTagVersions(sorted[i], sorted[j])
+		return c > 0
+	})
+	if count > 0 && len(sorted) > count {
+		sorted = sorted[:count]
+	}
+	return sorted
+}
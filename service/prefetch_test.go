@@ -0,0 +1,55 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_latestVersions(t *testing.T) {
+	versions := []string{"v1.0.0", "v1.10.0", "invalid", "v1.2.0", "v0.9.0"}
+	assert.Equal(t, []string{"v1.10.0", "v1.2.0", "v1.0.0", "v0.9.0"}, latestVersions(versions, 0))
+	assert.Equal(t, []string{"v1.10.0", "v1.2.0"}, latestVersions(versions, 2))
+	assert.Equal(t, []string{"v1.10.0", "v1.2.0", "v1.0.0", "v0.9.0"}, latestVersions(versions, 10))
+	assert.Empty(t, latestVersions(nil, 1))
+}
+
+func Test_GoProxy_Prefetch(t *testing.T) {
+	s := &sourceMock{
+		versions: map[uint][]string{
+			1: {"v0.1.0", "v1.0.0", "v1.1.0"},
+			2: {"v2.0.0"},
+		},
+		downloaded: make(chan string, 10),
+	}
+	p := &GoProxy{
+		log: logger.Type("service.GoProxy"),
+		modules: map[string]source.Source{
+			"example.com":   nil,
+			"example.com/a": s,
+		},
+		files: storage.Dir{
+			Chroot: t.TempDir(),
+		},
+	}
+
+	result := p.Prefetch(context.Background(), PrefetchOptions{Latest: 2, Concurrency: 2})
+	assert.Equal(t, PrefetchResult{Downloaded: 3}, result)
+	close(s.downloaded)
+	downloaded := []string(nil)
+	for v := range s.downloaded {
+		downloaded = append(downloaded, v)
+	}
+	sort.Strings(downloaded)
+	assert.Equal(t, []string{"v1.0.0", "v1.1.0", "v2.0.0"}, downloaded)
+}
@@ -5,21 +5,30 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"go.lstv.dev/goproxy/client"
 	"go.lstv.dev/goproxy/logger"
 	"go.lstv.dev/goproxy/source"
 	"go.lstv.dev/goproxy/storage"
 	"go.lstv.dev/goproxy/util"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const DefaultDownloadsPathPrefix = "/dl"
@@ -28,20 +37,40 @@ type GoProxy struct {
 	log                 logger.Logger
 	server              http.Server
 	versions            VersionsConfig
+	webhooks            WebhooksConfig
+	admin               AdminConfig
+	auth                *authenticator
+	acl                 *accessList
+	prefetch            *prefetchStatus
+	tasks               *backgroundTasks
+	shutdownTimeout     time.Duration
 	defaultGoProxyURL   string // exclude ending slash
 	downloadsPathPrefix string // include starting slash, exclude ending slash
+	externalURL         string // exclude ending slash, empty if not configured
 	modules             map[string]source.Source
 	downloads           map[string]source.Downloads
+	downloadOptions     map[string]downloadOptions
+	downloadsSignKey    []byte
+	tracing             TracingConfig // installed by Start
+	latestDownloads     *latestDownloadCache
+	downloadChecksums   *downloadChecksumCache
+	storageMetrics      *storageMetrics
 	sources             map[string]source.Source
 	files               storage.Dir
 }
 
 func NewGoProxy(config *Config) (*GoProxy, error) {
+	return newGoProxy(config, nil, nil)
+}
+
+// newGoProxy creates GoProxy sharing background tasks and prefetch status with the current GoProxy on reload,
+// new ones are created if they are nil.
+func newGoProxy(config *Config, tasks *backgroundTasks, prefetch *prefetchStatus) (*GoProxy, error) {
 	log := logger.Type("service.GoProxy")
 
 	// configuring default go proxy url
 	if config.DefaultGoProxyURL == "" {
-		log.Fatal("missing default_go_proxy_url configuration")
+		return nil, errors.New("missing default_go_proxy_url configuration")
 	}
 	defaultGoProxyURL := config.DefaultGoProxyURL
 	if _, err := url.Parse(defaultGoProxyURL); err != nil {
@@ -63,32 +92,131 @@ func NewGoProxy(config *Config) (*GoProxy, error) {
 		"downloads_path_prefix", downloadsPathPrefix,
 	).Info("configured downloads path prefix")
 
+	// configuring external url
+	if config.ExternalURL != "" {
+		if u, err := url.Parse(config.ExternalURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, errors.New("invalid external_url: expected absolute URL")
+		}
+		if strings.HasSuffix(config.ExternalURL, "/") {
+			return nil, errors.New("invalid external_url: unexpected ending slash")
+		}
+		log.With(
+			"external_url", config.ExternalURL,
+		).Info("configured external url")
+	}
+
+	// configuring webhooks
+	if config.Webhooks.GitLab != nil {
+		if config.Webhooks.GitLab.SecretToken == "" {
+			return nil, errors.New("invalid webhooks: missing gitlab secret_token")
+		}
+		log.Info("configured gitlab webhook")
+	}
+
+	// configuring server timeouts
+	timeouts, err := config.Server.timeouts()
+	if err != nil {
+		return nil, err
+	}
+
+	// configuring tls
+	var certificates *certificateLoader
+	if config.TLS != nil {
+		certificates, err = newCertificateLoader(*config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		log.With(
+			"cert_file", config.TLS.CertFile,
+			"client_ca_file", config.TLS.ClientCAFile,
+		).Info("configured tls")
+	}
+
+	if tasks == nil {
+		tasks = newBackgroundTasks()
+	}
+	if prefetch == nil {
+		prefetch = &prefetchStatus{}
+	}
+
 	// create new GoProxy
 	p := &GoProxy{
 		log: log,
 		server: http.Server{
-			Addr: config.Addr,
+			Addr:              config.Addr,
+			ReadHeaderTimeout: timeouts.readHeader,
+			ReadTimeout:       timeouts.read,
+			WriteTimeout:      timeouts.write,
+			IdleTimeout:       timeouts.idle,
 		},
 		versions:            config.Versions,
+		webhooks:            config.Webhooks,
+		admin:               config.Admin,
+		prefetch:            prefetch,
+		tasks:               tasks,
+		shutdownTimeout:     timeouts.shutdown,
 		defaultGoProxyURL:   defaultGoProxyURL,
 		downloadsPathPrefix: downloadsPathPrefix,
+		externalURL:         config.ExternalURL,
 		modules:             map[string]source.Source{},
 		downloads:           map[string]source.Downloads{},
+		downloadOptions:     map[string]downloadOptions{},
+		downloadsSignKey:    []byte(config.DownloadsSignKey),
+		tracing:             config.Tracing,
+		latestDownloads:     newLatestDownloadCache(),
+		downloadChecksums:   newDownloadChecksumCache(),
+		storageMetrics:      &storageMetrics{},
 		sources:             map[string]source.Source{},
 		files: storage.Dir{
 			Chroot: config.Storage,
 		},
 	}
 	p.server.Handler = p
+	if certificates != nil {
+		p.server.TLSConfig = certificates.serverConfig()
+	}
+	p.server.BaseContext = func(net.Listener) context.Context {
+		// requests in progress are cancelled at shutdown
+		return p.tasks.context()
+	}
 	if err := p.loadSources(config); err != nil {
 		return nil, err
 	}
+	auth, err := newAuthenticator(config.Auth, p.sources)
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil {
+		log.Info("configured client authentication")
+	}
+	p.auth = auth
+	acl, err := newAccessList(config.ACL)
+	if err != nil {
+		return nil, err
+	}
+	if acl != nil {
+		if auth == nil {
+			return nil, errors.New("invalid acl: client authentication must be configured")
+		}
+		log.Info("configured access list")
+	}
+	p.acl = acl
 	if err := p.loadModules(config); err != nil {
 		return nil, err
 	}
 	if err := p.loadDownloads(config); err != nil {
 		return nil, err
 	}
+
+	// configuring tracing, the provider is installed by Start, so constructing GoProxy has no global side effects
+	if err := config.Tracing.validate(); err != nil {
+		return nil, err
+	}
+	if config.Tracing.Endpoint != "" {
+		log.With(
+			"endpoint", config.Tracing.Endpoint,
+		).Info("configured tracing")
+	}
 	return p, nil
 }
 
@@ -105,9 +233,13 @@ func (p *GoProxy) loadSources(config *Config) error {
 		if !ok {
 			return fmt.Errorf("invalid source [%d]: expected type as string", i)
 		}
-		delete(s, "name")
-		delete(s, "type")
-		s, err := source.New(typ, s)
+		params := make(map[string]any, len(s))
+		for k, v := range s {
+			if k != "type" {
+				params[k] = v
+			}
+		}
+		s, err := source.New(typ, params)
 		if err != nil {
 			return fmt.Errorf("invalid source [%d]: unable to create source: %w", i, err)
 		}
@@ -161,45 +293,137 @@ func (p *GoProxy) loadDownloads(config *Config) error {
 		if err != nil {
 			return fmt.Errorf("invalid downloads [%s]: unable to parametrize source: %w", name, err)
 		}
+		options, err := d.options()
+		if err != nil {
+			return fmt.Errorf("invalid downloads [%s]: %w", name, err)
+		}
+		if _, ok := ds.(source.DownloadLocator); options.redirect == redirectUpstream && !ok {
+			return fmt.Errorf("invalid downloads [%s]: source does not support upstream redirect", name)
+		}
+		if options.redirect == redirectSigned && len(p.downloadsSignKey) == 0 {
+			return fmt.Errorf("invalid downloads [%s]: signed redirect requires downloads_sign_key", name)
+		}
 		p.log.With(
 			"name", name,
 			"source", d.Source,
 		).Info("added downloads")
 		p.downloads[name] = ds
+		p.downloadOptions[name] = options
 	}
 	return nil
 }
 
+// Start installs tracing provider, listens and serves requests until Shutdown is called.
 func (p *GoProxy) Start() error {
+	p.startTracing()
+	return p.listenAndServe()
+}
+
+func (p *GoProxy) listenAndServe() error {
 	p.log.With(
 		"addr", p.server.Addr,
 		"version", util.BuiltinVersion(),
 	).Info("start")
+	if p.server.TLSConfig != nil {
+		// certificates are provided by TLSConfig
+		return p.server.ListenAndServeTLS("", "")
+	}
 	return p.server.ListenAndServe()
 }
 
 func (p *GoProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.tasks.add()
+	defer p.tasks.done()
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
+	access := &accessEntry{
+		requestID: requestIDOf(req),
+		action:    p.requestAction(req.URL.Path),
+	}
+	w.Header().Set(requestIDHeader, access.requestID)
+	// W3C trace context of the client is continued
+	remoteCtx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	spanCtx, span := otel.Tracer(tracerName).Start(remoteCtx, "ServeHTTP",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.target", req.URL.Path),
+			attribute.String("goproxy.action", access.action),
+		),
+	)
+	req = req.WithContext(contextWithAccessEntry(spanCtx, access))
+	defer func() {
+		status := sw.statusCode()
+		observeRequest(access.action, status, start)
+		logAccess(req, access, sw, start)
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if status >= http.StatusInternalServerError {
+			endSpan(span, fmt.Errorf("status code %d", status))
+			return
+		}
+		endSpan(span, nil)
+	}()
+
+	// endpoints without client authentication
 	switch req.URL.Path {
-	case "/":
-		client.ServeClient(p, w, req)
-		return
 	case "/favicon.ico":
 		http.NotFound(w, req)
 		return
 	case "/healthz":
 		w.WriteHeader(http.StatusNoContent)
 		return
+	case metricsPath:
+		// metrics are authorized by admin token
+		p.serveMetrics(w, req)
+		return
+	case "/hooks/gitlab":
+		p.GitLabHook(w, req)
+		return
+	}
+	if path := req.URL.Path; strings.HasPrefix(path, adminPathPrefix+"/") {
+		p.serveAdmin(w, req)
+		return
+	}
+
+	ctx := requestContext(req, access.requestID)
+	// signed downloads are authorized by signature
+	if path := req.URL.Path; strings.HasPrefix(path, p.downloadsPathPrefix) && req.URL.Query().Has(signatureParam) {
+		p.serveSignedDownload(ctx, w, req, path[len(p.downloadsPathPrefix):])
+		return
+	}
+	if p.auth != nil {
+		identity, err := p.auth.authenticate(ctx, req)
+		if err != nil {
+			p.log.Ctx(ctx).Err(err).Debug("authentication failed")
+			writeAccessError(w, err)
+			return
+		}
+		ctx = logger.ContextWith(contextWithIdentity(ctx, identity),
+			"user", identity.Name,
+		)
+		access.setUser(identity.Name)
+		if identity.credentials != nil {
+			ctx = source.ContextWithCredentials(ctx, *identity.credentials)
+		}
+	} else if c, ok := requestCredentials(req); ok {
+		ctx = source.ContextWithCredentials(ctx, c)
+	}
+	req = req.WithContext(ctx)
+
+	identity := IdentityFromContext(ctx)
+	switch req.URL.Path {
+	case "/":
+		client.ServeClient(&aclClient{p: p, identity: identity}, w, req)
+		return
 	case "/versions.json":
 		p.Versions(w, req)
 		return
 	}
 
-	ctx := logger.ContextWith(req.Context(),
-		"request_id", util.GenerateUniqueID(),
-	)
 	// check downloads prefix
 	if path := req.URL.Path; strings.HasPrefix(path, p.downloadsPathPrefix) {
-		p.serveDownload(ctx, w, path[len(p.downloadsPathPrefix):], req.URL.Query())
+		p.serveDownload(ctx, w, req, path[len(p.downloadsPathPrefix):])
 		return
 	}
 	// parse url
@@ -209,6 +433,17 @@ func (p *GoProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			"url", req.URL.Path,
 		).Debug("unknown url")
 	}
+	if err == nil {
+		access.setModule(module, version)
+	}
+	// hidden module is not found
+	if err == nil && !p.acl.AllowModule(identity, util.RemoveVersionSuffix(module)) {
+		p.log.Ctx(ctx).With(
+			"module", module,
+		).Debug("access denied")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	// if module is not configured, fallthrough to default go proxy
 	s, ok := p.modules[util.RemoveVersionSuffix(module)]
 	if err != nil || !ok {
@@ -216,6 +451,7 @@ func (p *GoProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		p.log.Ctx(ctx).With(
 			"url", defaultGoProxyURL,
 		).Debug("redirect")
+		access.setRedirect(defaultGoProxyURL)
 		http.Redirect(w, req, defaultGoProxyURL, http.StatusTemporaryRedirect)
 		return
 	}
@@ -227,15 +463,23 @@ func (p *GoProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	// handle only GET method
-	if req.Method != http.MethodGet {
-		p.log.Ctx(ctx).Debug("expected GET method")
+	// check access of client credentials forwarded to the source
+	if err := checkAccess(ctx, s); err != nil {
+		p.log.Ctx(ctx).Err(err).With(
+			"module", module,
+		).Debug("source access check failed")
+		writeAccessError(w, err)
+		return
+	}
+	// handle only GET and HEAD methods
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		p.log.Ctx(ctx).Debug("expected GET or HEAD method")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	// handle list action
 	if action == "list" {
-		if err := p.serveList(ctx, w, module, s); err != nil {
+		if err := p.serveList(ctx, w, req, module, s); err != nil {
 			p.log.Ctx(ctx).Err(err).With(
 				"module", module,
 			).Debug("unable to list module versions")
@@ -244,8 +488,9 @@ func (p *GoProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	// get latest version
-	if version == "latest" {
-		latest, err := p.latestVersion(ctx, module, s)
+	latest := version == "latest"
+	if latest {
+		resolved, err := p.latestVersion(ctx, module, s)
 		if err != nil {
 			p.log.Ctx(ctx).Err(err).With(
 				"module", module,
@@ -255,18 +500,19 @@ func (p *GoProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 		p.log.Ctx(ctx).With(
 			"module", module,
-			"version", version,
+			"version", resolved,
 		).Debug("translate latest to version")
-		version = latest
+		version = resolved
+		access.setModule(module, version)
 	}
 	// if there is no stored version, download module
-	if ok, err := p.files.HasVersion(module, version); !ok {
+	if ok, err := p.hasVersion(module, version); !ok {
 		if err != nil {
 			p.log.Ctx(ctx).Err(err).Debug("unable to check module version")
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		if err := s.DownloadModule(ctx, p.files.Chroot, version); err != nil {
+		if err := p.downloadModule(ctx, s, p.files.Chroot, version); err != nil {
 			p.log.Ctx(ctx).Err(err).Debug("unable to download module")
 			if source.IsVersionNotFound(err) {
 				w.WriteHeader(http.StatusNotFound)
@@ -277,7 +523,7 @@ func (p *GoProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 	// serve stored module
-	if err := p.serve(ctx, w, module, version, action); err != nil {
+	if err := p.serve(ctx, w, req, module, version, action, latest); err != nil {
 		p.log.Ctx(ctx).Err(err).Debug("unable to serve response")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -299,7 +545,7 @@ func (p *GoProxy) ConfiguredModules() [][]string {
 	for _, n := range names {
 		c := []string{n}
 		if m := p.modules[n]; m != nil {
-			c = append(c, m.ConfigPreview()...)
+			c = append(c, redactPreview(m.ConfigPreview())...)
 		} else {
 			c = append(c,
 				"type", "null",
@@ -311,10 +557,47 @@ func (p *GoProxy) ConfiguredModules() [][]string {
 	return modules
 }
 
+// redactPreview replaces secret values of configuration preview.
+func redactPreview(pairs []string) []string {
+	for i := range pairs {
+		pairs[i] = logger.Redact(pairs[i])
+	}
+	return pairs
+}
+
 func (p *GoProxy) StoredModules() ([]storage.StoredModuleInfo, error) {
 	return p.files.StoredModules()
 }
 
+// AdminEnabled reports whether admin API is configured.
+func (p *GoProxy) AdminEnabled() bool {
+	return p.admin.Token != ""
+}
+
+// InvalidateCaches drops caches of all sources, modules and downloads.
+func (p *GoProxy) InvalidateCaches() {
+	for _, s := range p.sources {
+		if c, ok := s.(source.CacheInvalidator); ok {
+			c.InvalidateCache()
+		}
+	}
+	for _, s := range p.modules {
+		if c, ok := s.(source.CacheInvalidator); ok {
+			c.InvalidateCache()
+		}
+	}
+	for _, d := range p.downloads {
+		if c, ok := d.(source.CacheInvalidator); ok {
+			c.InvalidateCache()
+		}
+	}
+	p.latestDownloads.invalidate()
+	p.downloadChecksums.invalidate()
+	if p.auth != nil && p.auth.cache != nil {
+		p.auth.cache.invalidate()
+	}
+}
+
 func (p *GoProxy) DownloadNames() []string {
 	names := make([]string, 0, len(p.downloads))
 	for n := range p.downloads {
@@ -330,7 +613,7 @@ func (p *GoProxy) ConfiguredDownloads() [][]string {
 	for _, n := range names {
 		c := []string{n}
 		if d := p.downloads[n]; d != nil {
-			c = append(c, d.ConfigPreview()...)
+			c = append(c, redactPreview(d.ConfigPreview())...)
 		}
 		downloads = append(downloads, c)
 	}
@@ -342,6 +625,7 @@ func (p *GoProxy) Versions(w http.ResponseWriter, req *http.Request) {
 	log := p.log.With(
 		"func", "Versions",
 	)
+	identity := IdentityFromContext(ctx)
 
 	latestVersions := map[string]any{}
 	content := map[string]any{
@@ -349,7 +633,7 @@ func (p *GoProxy) Versions(w http.ResponseWriter, req *http.Request) {
 		"latest_versions": latestVersions,
 	}
 	for moduleWithoutVersionSuffix, s := range p.modules {
-		if s == nil {
+		if s == nil || !p.acl.AllowModule(identity, moduleWithoutVersionSuffix) || checkAccess(ctx, s) != nil {
 			continue
 		}
 		module, version, err := p.latestMajorVersion(ctx, moduleWithoutVersionSuffix, s)
@@ -362,6 +646,9 @@ func (p *GoProxy) Versions(w http.ResponseWriter, req *http.Request) {
 		latestVersions[module] = version
 	}
 	for _, module := range p.versions.Modules {
+		if !p.acl.AllowModule(identity, util.RemoveVersionSuffix(module)) {
+			continue
+		}
 		version, err := p.latestVersionFromDefaultProxy(ctx, module)
 		if err != nil {
 			log.Err(err).With(
@@ -378,7 +665,7 @@ func (p *GoProxy) Versions(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (p *GoProxy) serveList(ctx context.Context, w http.ResponseWriter, module string, s source.Source) error {
+func (p *GoProxy) serveList(ctx context.Context, w http.ResponseWriter, req *http.Request, module string, s source.Source) error {
 	log := p.log.Ctx(ctx).With(
 		"func", "serveList",
 	)
@@ -398,15 +685,32 @@ func (p *GoProxy) serveList(ctx context.Context, w http.ResponseWriter, module s
 		"module", module,
 		"action", "list",
 	).Info("serve")
-	setContentType(w, "text")
+	b := bytes.Buffer{}
 	for _, v := range versions {
-		log.NoErrLast(w.Write([]byte(v)))
-		log.NoErrLast(w.Write([]byte("\r\n")))
+		b.WriteString(v)
+		b.WriteString("\r\n")
 	}
+	sum := sha256.Sum256(b.Bytes())
+	p.setCacheControl(w, mutableMaxAge, false)
+	if notModified(w, req, contentETag(sum[:]), time.Time{}) {
+		return nil
+	}
+	setContentType(w, "text")
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	if req.Method == http.MethodHead {
+		return nil
+	}
+	log.NoErrLast(w.Write(b.Bytes()))
 	return nil
 }
 
-func (p *GoProxy) latestVersion(ctx context.Context, module string, s source.Source) (string, error) {
+func (p *GoProxy) latestVersion(ctx context.Context, module string, s source.Source) (latest string, err error) {
+	ctx, span := startSpan(ctx, "latestVersion",
+		attribute.String("module", module),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
 	major := util.VersionSuffix(module)
 	version, err := s.LatestVersion(ctx, major)
 	if err != nil {
@@ -447,7 +751,8 @@ func (p *GoProxy) latestMajorVersion(ctx context.Context, module string, s sourc
 	return moduleWithVersionSuffix, version, nil
 }
 
-func (p *GoProxy) serve(ctx context.Context, w http.ResponseWriter, module, version, action string) error {
+// serve writes stored file of module version, files resolved from latest version are cached only shortly.
+func (p *GoProxy) serve(ctx context.Context, w http.ResponseWriter, req *http.Request, module, version, action string, latest bool) error {
 	log := p.log.Ctx(ctx).With(
 		"module", module,
 		"version", version,
@@ -456,29 +761,48 @@ func (p *GoProxy) serve(ctx context.Context, w http.ResponseWriter, module, vers
 	switch action {
 	case "info", "mod", "zip":
 		log.Trace("serve")
-		return p.serveFile(ctx, w, module, version, action)
+		return p.serveFile(ctx, w, req, module, version, action, latest)
 	default:
 		log.Trace("unknown action")
 		return nil
 	}
 }
 
-func (p *GoProxy) serveFile(_ context.Context, w http.ResponseWriter, module, version, suffix string) error {
+// serveFile writes stored file, Cache-Control is set only if the file is readable, so errors are not cached.
+func (p *GoProxy) serveFile(_ context.Context, w http.ResponseWriter, req *http.Request, module, version, suffix string, latest bool) error {
 	f, err := p.files.Open(module, version, suffix)
 	if err != nil {
 		return fmt.Errorf("unable to open %q file for module %q at version %q: %w", suffix, module, version, err)
 	}
 	defer p.log.NoErrClose(f)
-	setContentType(w, suffix)
-	if _, err := io.Copy(w, f); err != nil {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat %q file for module %q at version %q: %w", suffix, module, version, err)
+	}
+	etag, err := checksums.etag(f, info)
+	if err != nil {
 		return fmt.Errorf("unable to read %q file for module %q at version %q: %w", suffix, module, version, err)
 	}
+	if latest {
+		p.setCacheControl(w, mutableMaxAge, false)
+	} else {
+		p.setCacheControl(w, immutableMaxAge, true)
+	}
+	// conditional, range and HEAD requests are handled by ServeContent
+	w.Header().Set("ETag", etag)
+	setContentType(w, suffix)
+	http.ServeContent(w, req, "", info.ModTime(), f)
 	return nil
 }
 
-func (p *GoProxy) serveDownload(ctx context.Context, w http.ResponseWriter, relativePath string, query url.Values) {
+func (p *GoProxy) serveDownload(ctx context.Context, w http.ResponseWriter, req *http.Request, relativePath string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		p.log.Ctx(ctx).Debug("expected GET or HEAD method")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 	if relativePath == "/versions.json" {
-		p.serveDownloadVersions(ctx, w, query.Get("filter"))
+		p.serveDownloadVersions(ctx, w, req.URL.Query().Get("filter"))
 		return
 	}
 
@@ -486,57 +810,85 @@ func (p *GoProxy) serveDownload(ctx context.Context, w http.ResponseWriter, rela
 		"download_path", relativePath,
 	)
 
-	parts := strings.Split(relativePath, "/")
-	switch len(parts) {
-	case 3:
-		parts = append(parts, "")
-	case 4:
-		// no-op
-	default:
+	relativePath, suffix := downloadFileSuffix(relativePath)
+	name, version, arch, ok := splitDownloadPath(relativePath)
+	if !ok {
 		log.Debug("invalid url")
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	if parts[0] != "" {
-		log.Debug("expected / after download prefix")
-		w.WriteHeader(http.StatusNotFound)
-		return
+	all := suffix == "" && arch == checksumsFile
+	if all {
+		arch = ""
 	}
 
 	log = log.With(
-		"name", parts[1],
-		"version", parts[2],
-		"arch", parts[3],
+		"name", name,
+		"version", version,
+		"arch", arch,
 	)
 
-	ds, ok := p.downloads[parts[1]]
-	if !ok {
+	ds, ok := p.downloads[name]
+	if !ok || !p.acl.AllowDownload(IdentityFromContext(ctx), name) {
 		log.Debug("invalid download name")
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if err := checkAccess(ctx, ds); err != nil {
+		log.Err(err).Debug("download access check failed")
+		writeAccessError(w, err)
+		return
+	}
+	if suffix == "" && !all && arch == "" && (version == versionsJSON || version == versionsList) {
+		p.serveDownloadVersionList(ctx, w, req, name, ds, version == versionsJSON)
+		return
+	}
+	if suffix == "" && !all && arch == "" && version == installScript {
+		p.serveInstallScript(ctx, w, req, name, ds)
+		return
+	}
 
-	v := util.Version{}
-	if err := (error)(nil); parts[2] == "latest" {
-		v, err = ds.LatestDownloadVersion(ctx)
-		if err != nil {
-			log.Err(err).Debug("invalid download version")
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
+	v, latest, err := p.resolveDownloadVersion(ctx, name, ds, version)
+	if err != nil {
+		log.Err(err).Debug("invalid download version")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if latest {
 		log = log.With(
-			"latest_version", v,
+			"resolved_version", v,
 		)
-	} else {
-		v, err = util.ParseVersion(parts[2])
-		if err != nil {
-			log.Err(err).Debug("invalid download version")
-			w.WriteHeader(http.StatusNotFound)
+		w.Header().Set(resolvedVersionHeader, v.String())
+	}
+	if !all {
+		if arch, ok = p.resolveDownloadArchitecture(ctx, w, name, ds, v, arch); !ok {
 			return
 		}
 	}
 
-	ds.WriteDownload(ctx, w, v, parts[3])
+	accessEntryFromContext(ctx).setDownload(name, v.String())
+
+	g := downloadsInFlight.WithLabelValues("download")
+	g.Inc()
+	defer g.Dec()
+	ctx, span := startSpan(ctx, "WriteDownload",
+		attribute.String("name", name),
+		attribute.String("version", v.String()),
+		attribute.String("arch", arch),
+	)
+	defer endSpan(span, nil)
+	switch {
+	case all || suffix == checksumSuffix:
+		p.serveDownloadChecksums(ctx, w, req, name, ds, v, arch, all, latest)
+	case suffix != "":
+		p.serveDownloadSignature(ctx, w, req, ds, v, arch, suffix)
+	case p.downloadOptions[name].redirect != "":
+		p.redirectDownload(ctx, w, req, name, ds, v, arch)
+	case p.downloadOptions[name].cache:
+		p.serveStoredDownload(ctx, w, req, name, ds, v, arch, latest)
+	default:
+		ds.WriteDownload(ctx, w, req, v, arch)
+	}
 	log.Trace("download finished")
 }
 
@@ -547,8 +899,9 @@ func (p *GoProxy) serveDownloadVersions(ctx context.Context, w http.ResponseWrit
 	}{
 		LatestVersions: map[string]util.Version{},
 	}
+	identity := IdentityFromContext(ctx)
 	for name, download := range p.downloads {
-		if filter != "" && name != filter {
+		if (filter != "" && name != filter) || !p.acl.AllowDownload(identity, name) || checkAccess(ctx, download) != nil {
 			continue
 		}
 		v, err := download.LatestDownloadVersion(ctx)
@@ -3,3 +3,102 @@
 // that can be found in the LICENSE file.
 
 package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.lstv.dev/goproxy/logger"
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewGoProxy(t *testing.T) {
+	valid := TracingConfig{Endpoint: "http://127.0.0.1:1"}
+	cases := []struct {
+		Config Config
+		Error  string
+	}{
+		{Config: Config{Tracing: valid}, Error: "missing default_go_proxy_url configuration"},
+		{Config: Config{DefaultGoProxyURL: "https://proxy.golang.org/", Tracing: valid}, Error: "invalid default_go_proxy_url: unexpected ending slash"},
+		{Config: Config{DefaultGoProxyURL: "https://proxy.golang.org", Tracing: TracingConfig{Endpoint: "collector"}}, Error: "invalid tracing: expected endpoint as absolute URL"},
+		{Config: Config{DefaultGoProxyURL: "https://proxy.golang.org", ExternalURL: "goproxy.example.com"}, Error: "invalid external_url: expected absolute URL"},
+		{Config: Config{DefaultGoProxyURL: "https://proxy.golang.org", ExternalURL: "https://goproxy.example.com/"}, Error: "invalid external_url: unexpected ending slash"},
+		{Config: Config{DefaultGoProxyURL: "https://proxy.golang.org", Tracing: valid}},
+		{Config: Config{DefaultGoProxyURL: "https://proxy.golang.org", ExternalURL: "https://goproxy.example.com/proxy"}},
+	}
+	for i, c := range cases {
+		_, err := NewGoProxy(&c.Config)
+		if c.Error != "" {
+			assert.EqualErrorf(t, err, c.Error, "case %d", i)
+		} else {
+			assert.NoErrorf(t, err, "case %d", i)
+		}
+		// configuration is validated without side effects, e.g. by subcommand validate
+		assert.Nilf(t, setDefaultTracerProvider(nil), "case %d", i)
+	}
+}
+
+func Test_GoProxy_ServeHTTP_headAndRange(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "example.com/a"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com/a/v1.0.0.info"), []byte(`{}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "example.com/a/v1.0.0.zip"), []byte("0123456789"), 0644))
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+		modules: map[string]source.Source{
+			"example.com/a": &sourceMock{
+				versions: map[uint][]string{1: {"v1.0.0"}},
+			},
+		},
+		files: storage.Dir{
+			Chroot: dir,
+		},
+	}
+
+	cases := []struct {
+		Method  string
+		Path    string
+		Header  map[string]string
+		Status  int
+		Length  string
+		Body    string
+		Partial string
+	}{
+		{Method: http.MethodHead, Path: "/example.com/a/@v/v1.0.0.zip", Status: http.StatusOK, Length: "10"},
+		{Method: http.MethodHead, Path: "/example.com/a/@v/list", Status: http.StatusOK, Length: "8"},
+		{Method: http.MethodGet, Path: "/example.com/a/@v/v1.0.0.zip", Status: http.StatusOK, Length: "10", Body: "0123456789"},
+		{
+			Method: http.MethodGet,
+			Path:   "/example.com/a/@v/v1.0.0.zip",
+			Header: map[string]string{"Range": "bytes=7-"},
+			Status: http.StatusPartialContent, Length: "3", Body: "789", Partial: "bytes 7-9/10",
+		},
+		{
+			Method: http.MethodGet,
+			Path:   "/example.com/a/@v/v1.0.0.zip",
+			Header: map[string]string{"Range": "bytes=7-", "If-Range": `"outdated"`},
+			Status: http.StatusOK, Length: "10", Body: "0123456789",
+		},
+		{Method: http.MethodPost, Path: "/example.com/a/@v/v1.0.0.zip", Status: http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(c.Method, c.Path, http.NoBody)
+		for k, v := range c.Header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equalf(t, c.Status, w.Code, "case %d", i)
+		assert.Equalf(t, c.Length, w.Header().Get("Content-Length"), "case %d", i)
+		assert.Equalf(t, c.Body, w.Body.String(), "case %d", i)
+		assert.Equalf(t, c.Partial, w.Header().Get("Content-Range"), "case %d", i)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+)
+
+// Reloader serves requests by GoProxy built from configuration file
+// and rebuilds it when configuration is reloaded.
+//
+// The new GoProxy replaces the current one atomically, requests in progress are finished by the old one.
+// If the new configuration is invalid, the current GoProxy is kept.
+type Reloader struct {
+	log     logger.Logger
+	file    string
+	mutex   sync.Mutex // serializes reloads
+	config  *Config
+	initial *GoProxy          // owner of the server
+	started bool              // tracing of the current configuration is installed
+	hash    [sha256.Size]byte // hash of the last loaded file content
+	proxy   atomic.Value      // *GoProxy
+}
+
+// NewReloader loads configuration from file and creates GoProxy.
+func NewReloader(file string) (*Reloader, error) {
+	r := &Reloader{
+		log:  logger.Type("service.Reloader"),
+		file: file,
+	}
+	r.hash, _ = fileHash(file)
+	c, p, err := loadGoProxy(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.config = c
+	r.initial = p
+	r.proxy.Store(p)
+	return r, nil
+}
+
+// loadGoProxy loads configuration and creates GoProxy, which shares background tasks
+// and prefetch status with the current GoProxy if it is not nil.
+func loadGoProxy(file string, current *GoProxy) (*Config, *GoProxy, error) {
+	c, err := LoadConfig(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := logger.SetLevel(c.LogLevel); err != nil {
+		return nil, nil, fmt.Errorf("invalid log_level: %w", err)
+	}
+	var (
+		tasks    *backgroundTasks
+		prefetch *prefetchStatus
+	)
+	if current != nil {
+		// prefetch running in the background is not lost and tasks are cancelled at shutdown
+		tasks, prefetch = current.tasks, current.prefetch
+	}
+	p, err := newGoProxy(c, tasks, prefetch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, p, nil
+}
+
+// GoProxy returns the current GoProxy.
+func (r *Reloader) GoProxy() *GoProxy {
+	return r.proxy.Load().(*GoProxy)
+}
+
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.GoProxy().ServeHTTP(w, req)
+}
+
+// Start installs tracing of the current configuration, listens at address of the initial configuration
+// and serves requests by the current GoProxy.
+func (r *Reloader) Start() error {
+	r.mutex.Lock()
+	r.initial.server.Handler = r
+	r.GoProxy().startTracing()
+	r.started = true
+	r.mutex.Unlock()
+	return r.initial.listenAndServe()
+}
+
+// Shutdown gracefully stops the server and waits for requests and background tasks of all GoProxy instances.
+func (r *Reloader) Shutdown() error {
+	return r.initial.Shutdown()
+}
+
+// Reload loads configuration from file and replaces the current GoProxy.
+// If the configuration is invalid, error is returned and the current GoProxy is kept.
+func (r *Reloader) Reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.hash, _ = fileHash(r.file)
+	current := r.GoProxy()
+	c, p, err := loadGoProxy(r.file, current)
+	if err != nil {
+		r.log.Err(err).Error("reload failed, keeping current configuration")
+		// restore log level of the current configuration
+		r.log.NoErr(logger.SetLevel(r.config.LogLevel))
+		return err
+	}
+	// listener is not restarted
+	p.server.Addr = current.server.Addr
+	if c.Addr != r.config.Addr || c.Server != r.config.Server || !reflect.DeepEqual(c.TLS, r.config.TLS) {
+		r.log.With(
+			"addr", current.server.Addr,
+		).Warn("changed addr, server or tls requires restart")
+	}
+	r.log.With(diffConfig(r.config, c)...).Info("reloaded configuration")
+	// tracing provider is replaced only after successful reload
+	if r.started && !reflect.DeepEqual(c.Tracing, r.config.Tracing) {
+		p.startTracing()
+	}
+	r.config = c
+	r.proxy.Store(p)
+	return nil
+}
+
+// Watch reloads configuration on SIGHUP until context is done.
+// If interval is not zero, the file is also checked for changes of its content periodically.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	tick := (<-chan time.Time)(nil)
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			r.log.Info("received SIGHUP, reloading configuration")
+			_ = r.Reload()
+		case <-tick:
+			if r.fileChanged() {
+				r.log.Info("configuration file changed, reloading configuration")
+				_ = r.Reload()
+			}
+		}
+	}
+}
+
+func (r *Reloader) fileChanged() bool {
+	h, err := fileHash(r.file)
+	if err != nil {
+		r.log.Err(err).Warn("unable to read configuration file")
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return h != r.hash
+}
+
+func fileHash(file string) ([sha256.Size]byte, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
+
+// configChange is old and new value of changed part of configuration, credentials are masked.
+type configChange struct {
+	Path string          `json:"path"`
+	Old  json.RawMessage `json:"old,omitempty"`
+	New  json.RawMessage `json:"new,omitempty"`
+}
+
+// diffConfig returns key-value pairs describing changes of configuration.
+// Names of changed sources, modules, downloads and top-level keys are followed by "changes"
+// with old and new values, credentials are masked.
+func diffConfig(prev, next *Config) []any {
+	pairs := []any(nil)
+	changes := []configChange(nil)
+	add := func(key, prefix string, names []string, prev, next map[string]json.RawMessage) {
+		if len(names) == 0 {
+			return
+		}
+		sort.Strings(names)
+		pairs = append(pairs, key, names)
+		for _, name := range names {
+			changes = append(changes, configChange{
+				Path: prefix + name,
+				Old:  prev[name],
+				New:  next[name],
+			})
+		}
+	}
+	// changes are found in configurations with credentials, values are logged masked
+	maskedPrev, maskedNext := maskSecrets(prev), maskSecrets(next)
+	for _, d := range []struct {
+		key                    string
+		prev, next             map[string]json.RawMessage
+		maskedPrev, maskedNext map[string]json.RawMessage
+	}{
+		{
+			key:  "sources",
+			prev: namedSources(prev.Sources), next: namedSources(next.Sources),
+			maskedPrev: namedSources(maskedPrev.Sources), maskedNext: namedSources(maskedNext.Sources),
+		},
+		{
+			key:  "modules",
+			prev: namedModules(prev.Modules), next: namedModules(next.Modules),
+			maskedPrev: namedModules(maskedPrev.Modules), maskedNext: namedModules(maskedNext.Modules),
+		},
+		{
+			key:  "downloads",
+			prev: namedValues(prev.Downloads), next: namedValues(next.Downloads),
+			maskedPrev: namedValues(maskedPrev.Downloads), maskedNext: namedValues(maskedNext.Downloads),
+		},
+	} {
+		added, removed, changed := diffRaw(d.prev, d.next)
+		prefix := "/" + d.key + "/"
+		add("added_"+d.key, prefix, added, d.maskedPrev, d.maskedNext)
+		add("removed_"+d.key, prefix, removed, d.maskedPrev, d.maskedNext)
+		add("changed_"+d.key, prefix, changed, d.maskedPrev, d.maskedNext)
+	}
+	prevTop, nextTop := topLevelValues(prev), topLevelValues(next)
+	_, _, changed := diffRaw(prevTop, nextTop)
+	add("changed", "/", changed, topLevelValues(maskedPrev), topLevelValues(maskedNext))
+	if len(changes) != 0 {
+		pairs = append(pairs, "changes", changes)
+	}
+	return pairs
+}
+
+// topLevelValues returns top-level keys of configuration except sources, modules and downloads.
+func topLevelValues(c *Config) map[string]json.RawMessage {
+	m := map[string]json.RawMessage{}
+	_ = unmarshalRaw(c, &m)
+	for _, k := range []string{"sources", "modules", "downloads"} {
+		delete(m, k)
+	}
+	return m
+}
+
+func diffRaw(prev, next map[string]json.RawMessage) (added, removed, changed []string) {
+	for k, v := range next {
+		p, ok := prev[k]
+		switch {
+		case !ok:
+			added = append(added, k)
+		case !bytes.Equal(p, v):
+			changed = append(changed, k)
+		}
+	}
+	for k := range prev {
+		if _, ok := next[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	return added, removed, changed
+}
+
+func namedSources(sources []map[string]any) map[string]json.RawMessage {
+	m := map[string]json.RawMessage{}
+	for _, s := range sources {
+		name, _ := s["name"].(string)
+		m[name], _ = json.Marshal(s)
+	}
+	return m
+}
+
+func namedModules(modules []ModuleConfig) map[string]json.RawMessage {
+	m := map[string]json.RawMessage{}
+	for _, c := range modules {
+		m[c.Name], _ = json.Marshal(c)
+	}
+	return m
+}
+
+func namedValues[T any](values map[string]T) map[string]json.RawMessage {
+	m := map[string]json.RawMessage{}
+	for k, v := range values {
+		m[k], _ = json.Marshal(v)
+	}
+	return m
+}
+
+func unmarshalRaw(v any, raw *map[string]json.RawMessage) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, raw)
+}
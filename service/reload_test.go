@@ -0,0 +1,129 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Reloader_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	}
+	write(`{"addr": ":8080", "default_go_proxy_url": "https://proxy.golang.org", "modules": [{"name": "example.com/a", "source": null}]}`)
+	r, err := NewReloader(file)
+	require.NoError(t, err)
+	first := r.GoProxy()
+	assert.Equal(t, []string{"example.com/a"}, first.ModuleNames())
+	assert.False(t, r.fileChanged())
+
+	write(`{"addr": ":8080", "default_go_proxy_url": "https://proxy.golang.org", "modules": [{"name": "example.com/b", "source": null}]}`)
+	assert.True(t, r.fileChanged())
+	require.NoError(t, r.Reload())
+	assert.False(t, r.fileChanged())
+	second := r.GoProxy()
+	assert.Equal(t, []string{"example.com/b"}, second.ModuleNames())
+	assert.Same(t, first.prefetch, second.prefetch)
+	assert.Same(t, first.tasks, second.tasks, "reloaded proxy should share background tasks")
+
+	write(`{"addr": ":8080", "default_go_proxy_url": "https://proxy.golang.org/", "modules": []}`)
+	assert.Error(t, r.Reload())
+	assert.Same(t, second, r.GoProxy(), "invalid configuration should keep current proxy")
+	assert.False(t, r.fileChanged(), "invalid configuration should not be reloaded again until changed")
+
+	write(`{"addr": ":8080", "modules": []}`)
+	assert.EqualError(t, r.Reload(), "missing default_go_proxy_url configuration")
+	assert.Same(t, second, r.GoProxy(), "missing default_go_proxy_url should keep current proxy")
+}
+
+func Test_Reloader_tracing(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	}
+	write(`{"default_go_proxy_url": "https://proxy.golang.org", "tracing": {"endpoint": "http://127.0.0.1:1"}}`)
+	r, err := NewReloader(file)
+	require.NoError(t, err)
+	assert.Nil(t, setDefaultTracerProvider(nil), "tracing should not be installed before start")
+
+	// r.Start is simulated without listening
+	r.GoProxy().startTracing()
+	r.started = true
+	installed := setDefaultTracerProvider(nil)
+	require.NotNil(t, installed)
+	setDefaultTracerProvider(installed)
+
+	write(`{"default_go_proxy_url": "https://proxy.golang.org", "tracing": {"endpoint": "http://127.0.0.1:2", "sample_ratio": 2}}`)
+	assert.Error(t, r.Reload())
+	assert.Same(t, installed, setDefaultTracerProvider(installed), "failed reload should keep tracing")
+
+	write(`{"default_go_proxy_url": "https://proxy.golang.org", "tracing": {"endpoint": "http://127.0.0.1:2"}}`)
+	require.NoError(t, r.Reload())
+	reloaded := setDefaultTracerProvider(nil)
+	assert.NotNil(t, reloaded)
+	assert.NotSame(t, installed, reloaded)
+	assert.NoError(t, shutdownTracerProvider(context.Background(), reloaded))
+}
+
+func Test_diffConfig(t *testing.T) {
+	source := "gitlab"
+	prev := &Config{
+		Addr: ":8080",
+		Sources: []map[string]any{
+			{"name": "gitlab", "type": "gitlab", "auth": "secret-token"},
+		},
+		Modules: []ModuleConfig{
+			{Name: "example.com/a", Source: &source},
+			{Name: "example.com/b", Source: &source},
+		},
+		Downloads: map[string]DownloadConfig{
+			"tool": {Mode: "generic-packages", Source: source},
+		},
+	}
+	next := &Config{
+		Addr:     ":8080",
+		LogLevel: "debug",
+		Sources: []map[string]any{
+			{"name": "gitlab", "type": "gitlab", "auth": "new-secret-token"},
+		},
+		Modules: []ModuleConfig{
+			{Name: "example.com/a", Source: nil},
+			{Name: "example.com/c", Source: &source},
+		},
+	}
+	pairs := diffConfig(prev, next)
+	require.Len(t, pairs, 14)
+	assert.Equal(t, []any{
+		"changed_sources", []string{"gitlab"},
+		"added_modules", []string{"example.com/c"},
+		"removed_modules", []string{"example.com/b"},
+		"changed_modules", []string{"example.com/a"},
+		"removed_downloads", []string{"tool"},
+		"changed", []string{"log_level"},
+		"changes",
+	}, pairs[:13])
+	changes, err := json.Marshal(pairs[13])
+	require.NoError(t, err)
+	for _, expected := range []string{
+		`{"path":"/sources/gitlab","old":{"auth":"[redacted]","name":"gitlab","type":"gitlab"},"new":{"auth":"[redacted]","name":"gitlab","type":"gitlab"}}`,
+		`{"path":"/modules/example.com/c","new":{"name":"example.com/c","source":"gitlab","source_params":null}}`,
+		`{"path":"/modules/example.com/a","old":{"name":"example.com/a","source":"gitlab","source_params":null},"new":{"name":"example.com/a","source":null,"source_params":null}}`,
+		`{"path":"/log_level","old":"","new":"debug"}`,
+	} {
+		assert.Contains(t, string(changes), expected)
+	}
+	// credentials are masked
+	assert.NotContains(t, string(changes), "secret-token")
+	assert.Equal(t, "secret-token", prev.Sources[0]["auth"])
+	assert.Empty(t, diffConfig(prev, prev))
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+)
+
+const jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// ConfigSchema returns JSON Schema of configuration generated from Config type.
+// Parameters of sources depend on source type and are not restricted.
+func ConfigSchema() map[string]any {
+	schema := jsonSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = jsonSchemaDraft
+	schema["title"] = "Go Proxy configuration"
+	properties := schema["properties"].(map[string]any)
+	properties["sources"] = map[string]any{
+		"type": "array",
+		"items": map[string]any{
+			"type":     "object",
+			"required": []string{"name", "type"},
+			"properties": map[string]any{
+				"name": map[string]any{"type": "string"},
+				"type": map[string]any{"type": "string"},
+			},
+		},
+	}
+	// upstream redirect is not authorized by the proxy
+	download := properties["downloads"].(map[string]any)["additionalProperties"].(map[string]any)
+	redirect := download["properties"].(map[string]any)["redirect"].(map[string]any)
+	redirect["enum"] = []string{"", redirectUpstream, redirectSigned}
+	redirect["description"] = "Redirect clients instead of streaming downloads. GitLab API URLs of \"upstream\" " +
+		"require clients to send their own token, so \"upstream\" works only for public projects."
+	return schema
+}
+
+func jsonSchema(t reflect.Type) map[string]any {
+	if t.Implements(textMarshalerType) {
+		return map[string]any{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]any{
+			"anyOf": []any{
+				jsonSchema(t.Elem()),
+				map[string]any{"type": "null"},
+			},
+		}
+	case reflect.Struct:
+		properties := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			properties[name] = jsonSchema(f.Type)
+		}
+		return map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": jsonSchema(t.Elem()),
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{
+			"type":  "array",
+			"items": jsonSchema(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.lstv.dev/goproxy/logger"
+)
+
+const fileReferencePrefix = "file:"
+
+// envReferencePattern matches ${ENV_VAR} references and escaped dollar $$.
+var envReferencePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveReference returns content of file for value "file:<path>"
+// or value with expanded environment variables referenced as ${ENV_VAR}, "$$" is replaced by "$".
+// Resolved values are registered as secrets by resolveSecrets.
+func resolveReference(s, path string) (string, error) {
+	if strings.HasPrefix(s, fileReferencePrefix) {
+		file := s[len(fileReferencePrefix):]
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("%s: unable to read secret file: %w", path, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	err := error(nil)
+	resolved := envReferencePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		name := ref[2 : len(ref)-1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("%s: environment variable %q is not set", path, name)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// resolveSecrets resolves references in configured credentials and registers them as secrets,
+// so they are redacted from logs. Other values are used as they are, e.g. "$" in URLs is not an escape.
+func resolveSecrets(c *Config) error {
+	resolve := func(value *string, path string) error {
+		resolved, err := resolveReference(*value, path)
+		if err != nil {
+			return err
+		}
+		logger.AddSecret(resolved)
+		*value = resolved
+		return nil
+	}
+	resolveMap := func(m map[string]string, path string) error {
+		for k, v := range m {
+			if err := resolve(&v, path+"/"+k); err != nil {
+				return err
+			}
+			m[k] = v
+		}
+		return nil
+	}
+
+	for i, s := range c.Sources {
+		if auth, ok := s["auth"].(string); ok {
+			if err := resolve(&auth, "/sources/"+strconv.Itoa(i)+"/auth"); err != nil {
+				return err
+			}
+			s["auth"] = auth
+		}
+	}
+	if err := resolveMap(c.Auth.Users, "/auth/users"); err != nil {
+		return err
+	}
+	if err := resolveMap(c.Auth.Tokens, "/auth/tokens"); err != nil {
+		return err
+	}
+	if c.Webhooks.GitLab != nil {
+		if err := resolve(&c.Webhooks.GitLab.SecretToken, "/webhooks/gitlab/secret_token"); err != nil {
+			return err
+		}
+	}
+	if err := resolve(&c.Admin.Token, "/admin/token"); err != nil {
+		return err
+	}
+	if err := resolve(&c.DownloadsSignKey, "/downloads_sign_key"); err != nil {
+		return err
+	}
+	return resolveMap(c.Tracing.Headers, "/tracing/headers")
+}
+
+// maskSecrets returns copy of configuration with credentials replaced by logger.Redacted.
+func maskSecrets(c *Config) *Config {
+	masked := *c
+	mask := func(s string) string {
+		if s == "" {
+			return s
+		}
+		return logger.Redacted
+	}
+	maskMap := func(m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+		result := make(map[string]string, len(m))
+		for k, v := range m {
+			result[k] = mask(v)
+		}
+		return result
+	}
+
+	masked.Sources = nil
+	for _, s := range c.Sources {
+		source := make(map[string]any, len(s))
+		for k, v := range s {
+			source[k] = v
+		}
+		if auth, ok := s["auth"].(string); ok {
+			source["auth"] = mask(auth)
+		}
+		masked.Sources = append(masked.Sources, source)
+	}
+	masked.Auth.Users = maskMap(c.Auth.Users)
+	masked.Auth.Tokens = maskMap(c.Auth.Tokens)
+	if c.Webhooks.GitLab != nil {
+		masked.Webhooks.GitLab = &GitLabWebhookConfig{
+			SecretToken: mask(c.Webhooks.GitLab.SecretToken),
+		}
+	}
+	masked.Admin.Token = mask(c.Admin.Token)
+	masked.DownloadsSignKey = mask(c.DownloadsSignKey)
+	masked.Tracing.Headers = maskMap(c.Tracing.Headers)
+	return &masked
+}
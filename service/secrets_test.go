@@ -0,0 +1,109 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.lstv.dev/goproxy/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_resolveReference(t *testing.T) {
+	t.Setenv("GOPROXY_TEST_TOKEN", "env-token")
+	t.Setenv("GOPROXY_TEST_HOST", "gitlab.example.com")
+	file := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(file, []byte("file-token\n"), 0600))
+
+	cases := []struct {
+		value    string
+		expected string
+		err      bool
+	}{
+		{value: "plain", expected: "plain"},
+		{value: "${GOPROXY_TEST_TOKEN}", expected: "env-token"},
+		{value: "https://${GOPROXY_TEST_HOST}/", expected: "https://gitlab.example.com/"},
+		{value: "$${GOPROXY_TEST_TOKEN}", expected: "${GOPROXY_TEST_TOKEN}"},
+		{value: "$HOME", expected: "$HOME"},
+		{value: "file:" + file, expected: "file-token"},
+		{value: "${GOPROXY_TEST_MISSING}", err: true},
+		{value: "file:" + file + ".missing", err: true},
+	}
+	for i, c := range cases {
+		resolved, err := resolveReference(c.value, "/auth")
+		if c.err {
+			assert.Error(t, err, "case %d", i)
+			continue
+		}
+		assert.NoError(t, err, "case %d", i)
+		assert.Equal(t, c.expected, resolved, "case %d", i)
+	}
+}
+
+func Test_LoadConfig_references(t *testing.T) {
+	t.Setenv("GOPROXY_TEST_AUTH", "gitlab-token")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{
+		"sources": [{"name": "gitlab", "type": "gitlab", "auth": "${GOPROXY_TEST_AUTH}", "project_id": 1}],
+		"admin": {"token": "file:`+filepath.Join(dir, "missing")+`"}
+	}`), 0600))
+	_, err := LoadConfig(file)
+	assert.ErrorContains(t, err, "/admin/token")
+
+	secret := filepath.Join(dir, "admin-token")
+	require.NoError(t, os.WriteFile(secret, []byte("admin-token\n"), 0600))
+	require.NoError(t, os.WriteFile(file, []byte(`{
+		"default_go_proxy_url": "https://proxy.example.com/$$${GOPROXY_TEST_AUTH}",
+		"sources": [{"name": "gitlab", "type": "gitlab", "auth": "${GOPROXY_TEST_AUTH}", "project_id": 1, "url": "https://${GOPROXY_TEST_HOST}"}],
+		"auth": {"users": {"user": "pa$$word"}},
+		"admin": {"token": "file:`+secret+`"}
+	}`), 0600))
+	c, err := LoadConfig(file)
+	require.NoError(t, err)
+	assert.Equal(t, "gitlab-token", c.Sources[0]["auth"])
+	assert.Equal(t, json.Number("1"), c.Sources[0]["project_id"])
+	assert.Equal(t, "pa$word", c.Auth.Users["user"])
+	assert.Equal(t, "admin-token", c.Admin.Token)
+	assert.Equal(t, "auth [redacted]", logger.Redact("auth gitlab-token"))
+	assert.Equal(t, "token [redacted]", logger.Redact("token admin-token"))
+	// plain values containing $ are not references
+	assert.Equal(t, "https://proxy.example.com/$$${GOPROXY_TEST_AUTH}", c.DefaultGoProxyURL)
+	assert.Equal(t, "https://${GOPROXY_TEST_HOST}", c.Sources[0]["url"])
+}
+
+func Test_maskSecrets(t *testing.T) {
+	c := &Config{
+		DefaultGoProxyURL: "https://proxy.golang.org",
+		Sources:           []map[string]any{{"name": "gitlab", "auth": "gitlab-token"}},
+		Auth: AuthConfig{
+			Users:  map[string]string{"user": "password"},
+			Tokens: map[string]string{"ci": "ci-token"},
+		},
+		Webhooks:         WebhooksConfig{GitLab: &GitLabWebhookConfig{SecretToken: "webhook-token"}},
+		Admin:            AdminConfig{Token: "admin-token"},
+		DownloadsSignKey: "sign-key",
+		Tracing:          TracingConfig{Headers: map[string]string{"Authorization": "Bearer x"}},
+	}
+	masked := maskSecrets(c)
+	b, err := json.Marshal(masked)
+	require.NoError(t, err)
+	for _, secret := range []string{"gitlab-token", "password", "ci-token", "webhook-token", "admin-token", "sign-key", "Bearer x"} {
+		assert.NotContains(t, string(b), secret)
+	}
+	assert.Equal(t, "https://proxy.golang.org", masked.DefaultGoProxyURL)
+	assert.Equal(t, map[string]any{"name": "gitlab", "auth": logger.Redacted}, masked.Sources[0])
+	assert.Equal(t, map[string]string{"user": logger.Redacted}, masked.Auth.Users)
+	// the configuration is not modified
+	assert.Equal(t, "gitlab-token", c.Sources[0]["auth"])
+	assert.Equal(t, "password", c.Auth.Users["user"])
+	assert.Equal(t, "webhook-token", c.Webhooks.GitLab.SecretToken)
+	assert.Equal(t, "Bearer x", c.Tracing.Headers["Authorization"])
+}
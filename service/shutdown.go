@@ -0,0 +1,80 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultIdleTimeout       = 2 * time.Minute
+	DefaultShutdownTimeout   = 30 * time.Second
+)
+
+// backgroundTasks tracks requests and tasks running in the background to be cancelled at shutdown.
+// It is shared by reloaded GoProxy instances.
+type backgroundTasks struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBackgroundTasks() *backgroundTasks {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backgroundTasks{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// context returns context which is cancelled at shutdown.
+func (b *backgroundTasks) context() context.Context {
+	if b == nil {
+		return context.Background()
+	}
+	return b.ctx
+}
+
+// add registers a new task, done must be called when the task is finished.
+func (b *backgroundTasks) add() {
+	if b != nil {
+		b.wg.Add(1)
+	}
+}
+
+func (b *backgroundTasks) done() {
+	if b != nil {
+		b.wg.Done()
+	}
+}
+
+// Shutdown stops listening and waits for requests in progress until shutdown_timeout expires.
+// Then requests and background tasks in progress are cancelled
+// and Shutdown waits until they remove incomplete files.
+func (p *GoProxy) Shutdown() error {
+	log := p.log.With(
+		"shutdown_timeout", p.shutdownTimeout.String(),
+	)
+	log.Info("shutdown started")
+	ctx, cancel := context.WithTimeout(context.Background(), p.shutdownTimeout)
+	defer cancel()
+	err := p.server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Warn("shutdown timeout expired, cancelling requests in progress")
+		log.NoErr(p.server.Close())
+	}
+	p.tasks.cancel()
+	p.tasks.wg.Wait()
+	// spans of cancelled requests are exported too
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), p.shutdownTimeout)
+	defer flushCancel()
+	log.NoErr(shutdownTracerProvider(flushCtx, setDefaultTracerProvider(nil)))
+	log.Info("shutdown finished")
+	return err
+}
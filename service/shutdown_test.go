@@ -0,0 +1,75 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.lstv.dev/goproxy/source"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type blockingSourceMock struct {
+	source.Source
+	started chan struct{}
+	cleaned bool
+}
+
+func (s *blockingSourceMock) DownloadModule(ctx context.Context, _, _ string) error {
+	close(s.started)
+	<-ctx.Done()
+	s.cleaned = true
+	return ctx.Err()
+}
+
+func Test_GoProxy_Shutdown(t *testing.T) {
+	p, err := NewGoProxy(&Config{
+		Storage:           t.TempDir(),
+		DefaultGoProxyURL: "https://proxy.golang.org",
+		Server: ServerConfig{
+			ShutdownTimeout: "50ms",
+		},
+	})
+	require.NoError(t, err)
+	s := &blockingSourceMock{started: make(chan struct{})}
+	p.modules["example.com/a"] = s
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = p.server.Serve(l)
+	}()
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/example.com/a/@v/v1.0.0.info")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	select {
+	case <-s.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download not started")
+	}
+
+	assert.ErrorIs(t, p.Shutdown(), context.DeadlineExceeded)
+	assert.True(t, s.cleaned, "shutdown should wait for cancelled download")
+}
+
+func Test_ServerConfig_timeouts(t *testing.T) {
+	timeouts, err := ServerConfig{WriteTimeout: "1m", IdleTimeout: "0s"}.timeouts()
+	require.NoError(t, err)
+	assert.Equal(t, serverTimeouts{
+		readHeader: DefaultReadHeaderTimeout,
+		write:      time.Minute,
+		shutdown:   DefaultShutdownTimeout,
+	}, timeouts)
+	_, err = ServerConfig{ShutdownTimeout: "30"}.timeouts()
+	assert.ErrorContains(t, err, "invalid server shutdown_timeout")
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.lstv.dev/goproxy/logger"
+)
+
+// certificateCheckInterval limits how often files of certificates are checked for changes.
+const certificateCheckInterval = 10 * time.Second
+
+// certificateLoader loads server certificate and client CAs from files
+// and reloads them when modification time of any file changes.
+type certificateLoader struct {
+	log        logger.Logger
+	config     TLSConfig
+	minVersion uint16
+	mutex      sync.Mutex
+	checked    time.Time // last check of modification times
+	modTimes   []time.Time
+	current    *tls.Config
+}
+
+func newCertificateLoader(config TLSConfig) (*certificateLoader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("invalid tls: expected cert_file and key_file")
+	}
+	minVersion, err := tlsVersion(config.MinVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tls: %w", err)
+	}
+	l := &certificateLoader{
+		log: logger.Type("service.certificateLoader").With(
+			"cert_file", config.CertFile,
+		),
+		config:     config,
+		minVersion: minVersion,
+	}
+	if err := l.reload(); err != nil {
+		return nil, fmt.Errorf("invalid tls: %w", err)
+	}
+	return l, nil
+}
+
+func tlsVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported min_version %q, expected 1.2 or 1.3", version)
+	}
+}
+
+// serverConfig returns TLS configuration of server using the current certificate for every connection.
+func (l *certificateLoader) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: l.minVersion,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &l.configForClient().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return l.configForClient(), nil
+		},
+	}
+}
+
+// configForClient returns configuration with the current certificate.
+// If the files have changed, they are reloaded. Files are checked at most once per certificateCheckInterval. If reload fails, the previous certificate is kept.
+func (l *certificateLoader) configForClient() *tls.Config {
+	if l.changed() {
+		if err := l.reload(); err != nil {
+			l.log.Err(err).Error("unable to reload certificate, keeping previous one")
+		} else {
+			l.log.Info("reloaded certificate")
+		}
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.current
+}
+
+func (l *certificateLoader) files() []string {
+	files := []string{l.config.CertFile, l.config.KeyFile}
+	if l.config.ClientCAFile != "" {
+		files = append(files, l.config.ClientCAFile)
+	}
+	return files
+}
+
+func (l *certificateLoader) changed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(l.checked) < certificateCheckInterval {
+		return false
+	}
+	l.checked = now
+	for i, f := range l.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			// file is being replaced, the previous certificate is used
+			return false
+		}
+		if !info.ModTime().Equal(l.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *certificateLoader) reload() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	modTimes := make([]time.Time, 0, 3)
+	for _, f := range l.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+	// modification times are updated even if loading fails to not retry at every check
+	l.checked = time.Now()
+	l.modTimes = modTimes
+	cert, err := tls.LoadX509KeyPair(l.config.CertFile, l.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load certificate: %w", err)
+	}
+	c := &tls.Config{
+		MinVersion:   l.minVersion,
+		NextProtos:   []string{"h2", "http/1.1"},
+		Certificates: []tls.Certificate{cert},
+	}
+	if l.config.ClientCAFile != "" {
+		b, err := os.ReadFile(l.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("unable to load client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return errors.New("unable to load client CA: no certificate found")
+		}
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	l.current = c
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes self-signed certificate for 127.0.0.1 and its key to dir.
+func writeTestCertificate(t *testing.T, dir, name string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile, cert
+}
+
+func Test_certificateLoader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first := writeTestCertificate(t, dir, "server")
+	l, err := newCertificateLoader(TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"})
+	require.NoError(t, err)
+	c := l.configForClient()
+	assert.Equal(t, uint16(tls.VersionTLS13), c.MinVersion)
+	assert.Equal(t, first.Raw, c.Certificates[0].Certificate[0])
+
+	// replaced certificate is not checked until the interval elapses
+	_, _, second := writeTestCertificate(t, dir, "server")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	assert.Equal(t, first.Raw, l.configForClient().Certificates[0].Certificate[0])
+
+	// replaced certificate is reloaded
+	l.checked = time.Now().Add(-certificateCheckInterval)
+	assert.Equal(t, second.Raw, l.configForClient().Certificates[0].Certificate[0])
+
+	// invalid certificate keeps the previous one
+	require.NoError(t, os.WriteFile(certFile, []byte("invalid"), 0600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	l.checked = time.Now().Add(-certificateCheckInterval)
+	assert.Equal(t, second.Raw, l.configForClient().Certificates[0].Certificate[0])
+
+	_, err = newCertificateLoader(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	assert.ErrorContains(t, err, "unable to load certificate")
+	_, err = newCertificateLoader(TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.1"})
+	assert.ErrorContains(t, err, "unsupported min_version")
+	_, err = newCertificateLoader(TLSConfig{CertFile: certFile})
+	assert.ErrorContains(t, err, "expected cert_file and key_file")
+}
+
+func Test_GoProxy_mutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, serverCert := writeTestCertificate(t, dir, "server")
+	clientCertFile, clientKeyFile, _ := writeTestCertificate(t, dir, "client")
+	p, err := NewGoProxy(&Config{
+		DefaultGoProxyURL: "https://proxy.golang.org",
+		TLS: &TLSConfig{
+			CertFile:     certFile,
+			KeyFile:      keyFile,
+			ClientCAFile: clientCertFile,
+		},
+	})
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = p.server.ServeTLS(l, "", "")
+	}()
+	t.Cleanup(func() {
+		_ = p.server.Close()
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	require.NoError(t, err)
+	url := "https://" + l.Addr().String() + "/healthz"
+
+	client := &http.Client{Transport: &http.Transport{
+		ForceAttemptHTTP2: true,
+		TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{clientCert},
+		},
+	}}
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs: roots,
+	}}}
+	_, err = anonymous.Get(url)
+	assert.Error(t, err, "client certificate should be required")
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+
+	"go.lstv.dev/goproxy/logger"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	DefaultTracingServiceName = "goproxy"
+
+	tracerName = "go.lstv.dev/goproxy/service"
+)
+
+var (
+	tracerProviderMutex sync.Mutex
+	tracerProvider      *sdktrace.TracerProvider
+)
+
+// validate returns error if enabled tracing is configured incorrectly.
+func (c TracingConfig) validate() error {
+	if c.Endpoint == "" {
+		return nil
+	}
+	if u, err := url.Parse(c.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+		return errors.New("invalid tracing: expected endpoint as absolute URL")
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return errors.New("invalid tracing: expected sample_ratio between 0 and 1")
+	}
+	return nil
+}
+
+// newTracingProvider returns provider of validated tracing configuration or nil if tracing is disabled.
+// The exporter is started, so the provider is created only when it is installed.
+func newTracingProvider(config TracingConfig) (*sdktrace.TracerProvider, error) {
+	if config.Endpoint == "" {
+		return nil, nil
+	}
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultTracingServiceName
+	}
+	sampleRatio := config.SampleRatio
+	if sampleRatio == 0 {
+		sampleRatio = 1
+	}
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+		otlptracehttp.WithHeaders(config.Headers),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		)),
+	), nil
+}
+
+// setDefaultTracerProvider installs global tracer provider and W3C trace context propagation
+// and returns the previous provider, which should be shut down. Nil provider disables tracing.
+func setDefaultTracerProvider(tp *sdktrace.TracerProvider) (previous *sdktrace.TracerProvider) {
+	tracerProviderMutex.Lock()
+	defer tracerProviderMutex.Unlock()
+	previous = tracerProvider
+	tracerProvider = tp
+	if tp == nil {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	} else {
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			logger.Type("service.tracing").Err(err).Warn("unable to export spans")
+		}))
+	}
+	return previous
+}
+
+// shutdownTracerProvider exports remaining spans of provider.
+func shutdownTracerProvider(ctx context.Context, tp *sdktrace.TracerProvider) error {
+	if tp == nil {
+		return nil
+	}
+	return tp.Shutdown(ctx)
+}
+
+// setTracingProvider replaces the default tracing provider, the previous one exports remaining spans in the background.
+func setTracingProvider(log logger.Logger, tp *sdktrace.TracerProvider) {
+	previous := setDefaultTracerProvider(tp)
+	if previous != nil {
+		go func() {
+			log.NoErr(shutdownTracerProvider(context.Background(), previous))
+		}()
+	}
+}
+
+// startTracing installs tracing provider of the configuration as the default one.
+func (p *GoProxy) startTracing() {
+	tp, err := newTracingProvider(p.tracing)
+	if err != nil {
+		p.log.Err(err).Error("unable to start tracing")
+	}
+	setTracingProvider(p.log, tp)
+}
+
+// startSpan starts span of the default tracer provider, it is no-op if tracing is disabled.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan ends the span, non-nil err marks the span as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceID returns trace ID of span in ctx or an empty string.
+func traceID(ctx context.Context) string {
+	if c := trace.SpanContextFromContext(ctx); c.HasTraceID() {
+		return c.TraceID().String()
+	}
+	return ""
+}
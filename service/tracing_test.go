@@ -0,0 +1,85 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.lstv.dev/goproxy/logger"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_GoProxy_ServeHTTP_traceContext(t *testing.T) {
+	const (
+		remoteTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID        = "00f067aa0ba902b7"
+	)
+	p := &GoProxy{
+		log:                 logger.Type("service.GoProxy"),
+		downloadsPathPrefix: DefaultDownloadsPathPrefix,
+	}
+	// trace ID is not logged without tracing
+	req := httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody)
+	req.Header.Set("traceparent", "00-"+remoteTraceID+"-"+spanID+"-01")
+	assert.Equal(t, "", traceID(requestContext(req, "id")))
+
+	recorder := tracetest.NewSpanRecorder()
+	setDefaultTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		assert.NoError(t, shutdownTracerProvider(context.Background(), setDefaultTracerProvider(nil)))
+	})
+	for i, c := range []struct {
+		path   string
+		status int
+	}{
+		{path: "/healthz", status: http.StatusNoContent},
+		{path: "/admin/caches", status: http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodGet, c.path, http.NoBody)
+		req.Header.Set("traceparent", "00-"+remoteTraceID+"-"+spanID+"-01")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, c.status, w.Code, "case %d", i)
+	}
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	for i, s := range spans {
+		assert.Equal(t, "ServeHTTP", s.Name(), "case %d", i)
+		assert.Equal(t, remoteTraceID, s.SpanContext().TraceID().String(), "case %d", i)
+		assert.Equal(t, spanID, s.Parent().SpanID().String(), "case %d", i)
+		assert.Equal(t, codes.Unset, s.Status().Code, "case %d", i)
+	}
+	assert.Contains(t, spans[0].Attributes(), attribute.Int("http.status_code", http.StatusNoContent))
+	assert.Contains(t, spans[1].Attributes(), attribute.String("goproxy.action", "admin"))
+}
+
+func Test_endSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer func() {
+		assert.NoError(t, tp.Shutdown(context.Background()))
+	}()
+	_, span := tp.Tracer(tracerName).Start(context.Background(), "failed")
+	endSpan(span, assert.AnError)
+	_, span = tp.Tracer(tracerName).Start(context.Background(), "succeeded")
+	endSpan(span, nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, assert.AnError.Error(), spans[0].Status().Description)
+	assert.Len(t, spans[0].Events(), 1)
+	assert.Equal(t, codes.Unset, spans[1].Status().Code)
+}
@@ -0,0 +1,31 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package source
+
+import (
+	"context"
+)
+
+// Credentials of client which may be forwarded to the source.
+type Credentials struct {
+	User   string // user name of HTTP Basic, empty for bearer token
+	Token  string // HTTP Basic password or bearer token
+	Bearer bool
+}
+
+type credentialsCtxKeyType struct{}
+
+var credentialsCtxKey = credentialsCtxKeyType{}
+
+// ContextWithCredentials returns context carrying client credentials.
+func ContextWithCredentials(ctx context.Context, c Credentials) context.Context {
+	return context.WithValue(ctx, credentialsCtxKey, c)
+}
+
+// CredentialsFromContext returns client credentials carried by context.
+func CredentialsFromContext(ctx context.Context) (Credentials, bool) {
+	c, ok := ctx.Value(credentialsCtxKey).(Credentials)
+	return c, ok && c.Token != ""
+}
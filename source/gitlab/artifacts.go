@@ -0,0 +1,148 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/util"
+)
+
+// jobArtifacts are downloads of artifacts of CI job run for tags, versions are tags of the project.
+type jobArtifacts struct {
+	*Source
+	name         string
+	projectID    int64
+	tagPrefix    string
+	job          string
+	artifactPath assetTemplate
+}
+
+type tag struct {
+	Name   string `json:"name"`
+	Commit struct {
+		CreatedAt time.Time `json:"created_at"`
+	} `json:"commit"`
+}
+
+func (j *jobArtifacts) ConfigPreview() (pairs []string) {
+	return []string{
+		"type", "gitlab",
+		"mode", "job-artifacts",
+		"url", j.url,
+		"project_id", strconv.FormatInt(j.projectID, 10),
+		"tag_prefix", j.tagPrefix,
+		"job", j.job,
+		"artifact_path", string(j.artifactPath),
+		"insecure_tls", strconv.FormatBool(j.insecureTLS),
+		"forward_credentials", strconv.FormatBool(j.forwardCredentials),
+	}
+}
+
+// CheckAccess verifies access of forwarded client credentials to the project.
+func (j *jobArtifacts) CheckAccess(ctx context.Context) error {
+	return j.checkProjectAccess(ctx, j.projectID)
+}
+
+// Check verifies that the project is accessible with the source token and contains any tag.
+func (j *jobArtifacts) Check(ctx context.Context) error {
+	if err := j.checkProject(ctx, j.projectID); err != nil {
+		return err
+	}
+	if _, err := j.LatestDownloadVersion(ctx); err != nil {
+		return fmt.Errorf("Check: %w", err)
+	}
+	return nil
+}
+
+// tags returns all tags of the project with tag prefix.
+func (j *jobArtifacts) tags(ctx context.Context) ([]tag, error) {
+	tags := []tag(nil)
+	for page := 1; ; page++ {
+		url := j.apiURL(fmt.Sprintf("projects/%d/repository/tags?page=%d&per_page=100", j.projectID, page))
+		result := []tag(nil)
+		hasNextPage, err := j.getPage(ctx, url, page, &result)
+		if err != nil {
+			return nil, fmt.Errorf("tags: %w", err)
+		}
+		tags = append(tags, result...)
+		if !hasNextPage {
+			return tags, nil
+		}
+	}
+}
+
+func (j *jobArtifacts) LatestDownloadVersion(ctx context.Context) (latest util.Version, err error) {
+	versions, err := j.ListDownloadVersions(ctx)
+	if err != nil {
+		return util.Version{}, err
+	}
+	if len(versions) == 0 {
+		return util.Version{}, source.NewVersionNotFoundError(fmt.Errorf("no tag with prefix %q", j.tagPrefix))
+	}
+	return versions[0].Version, nil
+}
+
+// ListDownloadVersions returns versions of tags with tag prefix, architectures of artifacts are not known.
+func (j *jobArtifacts) ListDownloadVersions(ctx context.Context) ([]source.DownloadVersion, error) {
+	tags, err := j.tags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := []source.DownloadVersion(nil)
+	for _, t := range tags {
+		if !strings.HasPrefix(t.Name, j.tagPrefix) {
+			continue
+		}
+		v, err := util.ParseVersion(t.Name[len(j.tagPrefix):])
+		if err != nil {
+			continue
+		}
+		versions = append(versions, source.DownloadVersion{
+			Version:   v,
+			Published: t.Commit.CreatedAt,
+		})
+	}
+	sort.Slice(versions, func(i, k int) bool {
+		return versions[i].Version.Compare(versions[k].Version) > 0
+	})
+	return versions, nil
+}
+
+// artifactURL returns URL of artifact file of the job run for tag of version.
+func (j *jobArtifacts) artifactURL(v util.Version, path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = neturl.PathEscape(s)
+	}
+	return j.apiURL(fmt.Sprintf("projects/%d/jobs/artifacts/%s/raw/%s?job=%s",
+		j.projectID,
+		neturl.PathEscape(j.tagPrefix+v.String()),
+		strings.Join(segments, "/"),
+		neturl.QueryEscape(j.job),
+	))
+}
+
+// DownloadURL returns URL of artifact file, clients must be authorized by GitLab to download it.
+func (j *jobArtifacts) DownloadURL(_ context.Context, v util.Version, arch string) (string, error) {
+	return j.artifactURL(v, j.artifactPath.render(j.name, v, arch)), nil
+}
+
+func (j *jobArtifacts) WriteDownload(ctx context.Context, w http.ResponseWriter, req *http.Request, v util.Version, arch string) {
+	j.writeFile(ctx, w, req, j.artifactURL(v, j.artifactPath.render(j.name, v, arch)))
+}
+
+// DownloadLayout returns layout derived from artifact path template.
+func (j *jobArtifacts) DownloadLayout() (disableArchitecture bool, fileExtension string) {
+	return j.artifactPath.disableArchitecture(), j.artifactPath.fileExtension()
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package gitlab
+
+import (
+	"regexp"
+	"strings"
+
+	"go.lstv.dev/goproxy/util"
+)
+
+const (
+	namePlaceholder    = "{name}"
+	versionPlaceholder = "{version}"
+	archPlaceholder    = "{arch}"
+)
+
+// knownExtensions are file extensions of assets recognized by install scripts.
+var knownExtensions = []string{".tar.gz", ".tgz", ".zip", ".exe"}
+
+// assetTemplate is name of release asset or path of job artifact
+// with placeholders {name}, {version} and {arch}, e.g. "{name}-{version}-{arch}.tar.gz".
+type assetTemplate string
+
+// render returns asset name of version and architecture.
+func (t assetTemplate) render(name string, v util.Version, arch string) string {
+	return strings.NewReplacer(
+		namePlaceholder, name,
+		versionPlaceholder, v.String(),
+		archPlaceholder, arch,
+	).Replace(string(t))
+}
+
+// disableArchitecture reports whether template has no architecture placeholder.
+func (t assetTemplate) disableArchitecture() bool {
+	return !strings.Contains(string(t), archPlaceholder)
+}
+
+// fileExtension returns known extension of template.
+func (t assetTemplate) fileExtension() string {
+	for _, e := range knownExtensions {
+		if strings.HasSuffix(string(t), e) {
+			return e
+		}
+	}
+	return ""
+}
+
+// architecture returns architecture of asset name rendered by template for version.
+func (t assetTemplate) architecture(name string, v util.Version, assetName string) (string, bool) {
+	pattern := regexp.QuoteMeta(string(t))
+	pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(namePlaceholder), regexp.QuoteMeta(name))
+	pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(versionPlaceholder), regexp.QuoteMeta(v.String()))
+	pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(archPlaceholder), `([^/.]+)`)
+	r, err := regexp.Compile("^" + pattern + "$")
+	if err != nil {
+		return "", false
+	}
+	m := r.FindStringSubmatch(assetName)
+	switch {
+	case m == nil:
+		return "", false
+	case len(m) == 1:
+		return "", true
+	}
+	// all occurrences of placeholder are the same architecture
+	for _, arch := range m[2:] {
+		if arch != m[1] {
+			return "", false
+		}
+	}
+	return m[1], true
+}
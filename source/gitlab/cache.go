@@ -0,0 +1,114 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package gitlab
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// tagsCache holds tag names of a single project for a limited time.
+// Zero TTL disables caching.
+type tagsCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	expires time.Time
+	tags    []string
+}
+
+func newTagsCache(ttl time.Duration) *tagsCache {
+	return &tagsCache{
+		ttl: ttl,
+	}
+}
+
+func (c *tagsCache) get() ([]string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ttl <= 0 || c.tags == nil || time.Now().After(c.expires) {
+		return nil, false
+	}
+	return c.tags, true
+}
+
+func (c *tagsCache) set(tags []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	c.tags = tags
+	c.expires = time.Now().Add(c.ttl)
+}
+
+func (c *tagsCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tags = nil
+	c.expires = time.Time{}
+}
+
+type accessCacheKey struct {
+	token     [sha256.Size]byte
+	projectID int64
+}
+
+// accessCache holds client tokens with verified access to projects for a limited time.
+// Tokens are stored as hashes, zero TTL disables caching.
+type accessCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[accessCacheKey]time.Time
+}
+
+func newAccessCache(ttl time.Duration) *accessCache {
+	return &accessCache{
+		ttl:     ttl,
+		entries: map[accessCacheKey]time.Time{},
+	}
+}
+
+func (c *accessCache) allowed(token string, projectID int64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := accessCacheKey{
+		token:     sha256.Sum256([]byte(token)),
+		projectID: projectID,
+	}
+	expires, ok := c.entries[key]
+	if ok && time.Now().After(expires) {
+		delete(c.entries, key)
+		return false
+	}
+	return ok
+}
+
+func (c *accessCache) allow(token string, projectID int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	for key, expires := range c.entries {
+		if now.After(expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[accessCacheKey{
+		token:     sha256.Sum256([]byte(token)),
+		projectID: projectID,
+	}] = now.Add(c.ttl)
+}
+
+func (c *accessCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = map[accessCacheKey]time.Time{}
+}
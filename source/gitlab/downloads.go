@@ -11,8 +11,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"go.lstv.dev/goproxy/source"
 	"go.lstv.dev/goproxy/util"
 )
 
@@ -32,33 +36,111 @@ func (d *downloads) ConfigPreview() (pairs []string) {
 		"project_id", strconv.FormatInt(d.projectID, 10),
 		"package_name", d.packageName,
 		"insecure_tls", strconv.FormatBool(d.insecureTLS),
+		"forward_credentials", strconv.FormatBool(d.forwardCredentials),
 	}
 }
 
-func (d *downloads) WriteDownload(ctx context.Context, w http.ResponseWriter, v util.Version, arch string) {
-	log := d.log.Ctx(ctx)
-	url := d.apiURL(fmt.Sprintf("projects/%[1]d/packages/generic/%[2]s/%[3]s/%[2]s-%[3]s%[4]s",
+// CheckAccess verifies access of forwarded client credentials to the project.
+func (d *downloads) CheckAccess(ctx context.Context) error {
+	return d.checkProjectAccess(ctx, d.projectID)
+}
+
+// Check verifies that the project is accessible with the source token and contains any package.
+func (d *downloads) Check(ctx context.Context) error {
+	if err := d.checkProject(ctx, d.projectID); err != nil {
+		return err
+	}
+	latest, err := d.LatestDownloadVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("Check: %w", err)
+	}
+	if latest == (util.Version{}) {
+		return fmt.Errorf("Check: no package %q found", d.name)
+	}
+	return nil
+}
+
+// passedRequestHeaders are request headers of range requests passed to GitLab.
+var passedRequestHeaders = []string{"Range", "If-Range"}
+
+// passedResponseHeaders are response headers passed from GitLab.
+var passedResponseHeaders = []string{
+	"Accept-Ranges",
+	"Content-Disposition",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"ETag",
+	"Last-Modified",
+}
+
+func (d *downloads) packageURL(v util.Version, arch string) string {
+	return d.apiURL(fmt.Sprintf("projects/%[1]d/packages/generic/%[2]s/%[3]s/%[2]s-%[3]s%[4]s",
 		d.projectID,
 		d.packageName,
 		v,
 		d.extension(arch),
 	))
-	resp, err := d.doGetRequest(ctx, url)
+}
+
+// DownloadURL returns URL of generic package file, clients must be authorized by GitLab to download it.
+func (d *downloads) DownloadURL(_ context.Context, v util.Version, arch string) (string, error) {
+	return d.packageURL(v, arch), nil
+}
+
+func (d *downloads) WriteDownload(ctx context.Context, w http.ResponseWriter, req *http.Request, v util.Version, arch string) {
+	d.writeFile(ctx, w, req, d.packageURL(v, arch))
+}
+
+// WriteDownloadFile writes companion file of package file, e.g. tool-1.0.0-linux-amd64.tar.gz.sig.
+func (d *downloads) WriteDownloadFile(ctx context.Context, w http.ResponseWriter, req *http.Request, v util.Version, arch, suffix string) {
+	d.writeFile(ctx, w, req, d.packageURL(v, arch)+suffix)
+}
+
+// writeFile passes file at url to response of GET or HEAD request, range requests are passed as well.
+func (s *Source) writeFile(ctx context.Context, w http.ResponseWriter, req *http.Request, url string) {
+	log := s.log.Ctx(ctx)
+	method := http.MethodGet
+	if req.Method == http.MethodHead {
+		method = http.MethodHead
+	}
+	header := http.Header{}
+	for _, k := range passedRequestHeaders {
+		if v := req.Header.Values(k); len(v) != 0 {
+			header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	resp, err := s.doRequest(ctx, method, url, header)
 	if err != nil {
 		log.Err(err).Warn("download request failed")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	defer log.NoErrClose(resp.Body)
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+	default:
 		log.With(
 			"status_code", resp.StatusCode,
 		).Warn("download request failed: unexpected status code")
 		w.WriteHeader(resp.StatusCode)
 		return
 	}
+	for _, k := range passedResponseHeaders {
+		if v := resp.Header.Values(k); len(v) != 0 {
+			w.Header()[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	if resp.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.WriteHeader(resp.StatusCode)
+	if method == http.MethodHead {
+		return
+	}
 	if _, err := io.Copy(w, resp.Body); err != nil {
 		log.Err(err).Warn("download request failed")
+		source.AbortDownload(w, err)
 	}
 }
 
@@ -83,7 +165,7 @@ func (d *downloads) latestDownloadVersionPage(ctx context.Context, page int) (la
 	url := d.apiURL(fmt.Sprintf("projects/%d/packages?page=%d&package_type=generic&package_name=%s",
 		d.projectID,
 		page,
-		d.name,
+		d.packageName,
 	))
 	resp, err := d.doGetRequest(ctx, url)
 	if err != nil {
@@ -95,7 +177,7 @@ func (d *downloads) latestDownloadVersionPage(ctx context.Context, page int) (la
 		log.With(
 			"status_code", resp.StatusCode,
 		).Warn("fetch latest download version failed: unexpected status code")
-		return util.Version{}, false, err
+		return util.Version{}, false, fmt.Errorf("unexpected status code %d", resp.StatusCode)
 	}
 	result := ([]struct {
 		Version util.Version `json:"version"`
@@ -121,6 +203,177 @@ func (d *downloads) latestDownloadVersionPage(ctx context.Context, page int) (la
 	return latest, int(nextPage) != page, nil
 }
 
+// DownloadArchitectures returns architectures of package files of version.
+func (d *downloads) DownloadArchitectures(ctx context.Context, v util.Version) ([]string, error) {
+	id, err := d.packageID(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	return d.architectures(ctx, id, v)
+}
+
+// ListedArchitectures returns architectures of package files of listed version, the package is not looked up again.
+func (d *downloads) ListedArchitectures(ctx context.Context, dv source.DownloadVersion) ([]string, error) {
+	if dv.ID == 0 {
+		return d.DownloadArchitectures(ctx, dv.Version)
+	}
+	return d.architectures(ctx, dv.ID, dv.Version)
+}
+
+// ListDownloadVersions returns versions of all generic packages, architectures are listed by ListedArchitectures,
+// so resolution of versions needs only the list of packages.
+func (d *downloads) ListDownloadVersions(ctx context.Context) ([]source.DownloadVersion, error) {
+	packages, err := d.packages(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]source.DownloadVersion, 0, len(packages))
+	for _, p := range packages {
+		versions = append(versions, source.DownloadVersion{
+			Version:   p.Version,
+			Published: p.CreatedAt,
+			ID:        p.ID,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version.Compare(versions[j].Version) > 0
+	})
+	return versions, nil
+}
+
+// architectures returns sorted architectures of files of package.
+func (d *downloads) architectures(ctx context.Context, id int64, v util.Version) ([]string, error) {
+	files, err := d.packageFiles(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	archs := []string(nil)
+	seen := map[string]bool{}
+	for _, f := range files {
+		arch, ok := d.architecture(v, f.FileName)
+		if ok && !seen[arch] {
+			seen[arch] = true
+			archs = append(archs, arch)
+		}
+	}
+	sort.Strings(archs)
+	return archs, nil
+}
+
+// architecture returns architecture of package file name, companion files are skipped.
+func (d *downloads) architecture(v util.Version, fileName string) (string, bool) {
+	prefix := fmt.Sprintf("%s-%s", d.packageName, v)
+	if !strings.HasPrefix(fileName, prefix) || !strings.HasSuffix(fileName, d.fileExtension) {
+		return "", false
+	}
+	arch := strings.TrimSuffix(fileName[len(prefix):], d.fileExtension)
+	if d.disableArchitecture {
+		return "", arch == ""
+	}
+	if !strings.HasPrefix(arch, "-") {
+		return "", false
+	}
+	arch = arch[1:]
+	// companion files like .sig are not architectures
+	return arch, arch != "" && !strings.Contains(arch, ".")
+}
+
+type packageFile struct {
+	FileName string `json:"file_name"`
+}
+
+type packageInfo struct {
+	ID        int64        `json:"id"`
+	Version   util.Version `json:"version"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// packages returns generic packages of download, optionally filtered by version.
+func (d *downloads) packages(ctx context.Context, version string) ([]packageInfo, error) {
+	packages := []packageInfo(nil)
+	for page := 1; ; page++ {
+		url := d.apiURL(fmt.Sprintf("projects/%d/packages?page=%d&per_page=100&package_type=generic&package_name=%s",
+			d.projectID,
+			page,
+			d.packageName,
+		))
+		if version != "" {
+			url += "&package_version=" + version
+		}
+		result := []packageInfo(nil)
+		hasNextPage, err := d.getPage(ctx, url, page, &result)
+		if err != nil {
+			return nil, fmt.Errorf("packages: %w", err)
+		}
+		packages = append(packages, result...)
+		if !hasNextPage {
+			return packages, nil
+		}
+	}
+}
+
+// packageID returns ID of generic package of version.
+func (d *downloads) packageID(ctx context.Context, v util.Version) (int64, error) {
+	packages, err := d.packages(ctx, v.String())
+	if err != nil {
+		return 0, err
+	}
+	// older GitLab ignores package_version
+	for _, p := range packages {
+		if p.Version == v {
+			return p.ID, nil
+		}
+	}
+	return 0, source.NewVersionNotFoundError(fmt.Errorf("no package %q of version %s", d.packageName, v))
+}
+
+// packageFiles returns all files of package.
+func (d *downloads) packageFiles(ctx context.Context, id int64) ([]packageFile, error) {
+	files := []packageFile(nil)
+	for page := 1; ; page++ {
+		url := d.apiURL(fmt.Sprintf("projects/%d/packages/%d/package_files?page=%d&per_page=100",
+			d.projectID,
+			id,
+			page,
+		))
+		result := []packageFile(nil)
+		hasNextPage, err := d.getPage(ctx, url, page, &result)
+		if err != nil {
+			return nil, fmt.Errorf("packageFiles: %w", err)
+		}
+		files = append(files, result...)
+		if !hasNextPage {
+			return files, nil
+		}
+	}
+}
+
+// getPage decodes page of GitLab API list into result.
+func (s *Source) getPage(ctx context.Context, url string, page int, result any) (hasNextPage bool, err error) {
+	resp, err := s.doGetRequest(ctx, url)
+	if err != nil {
+		return false, err
+	}
+	defer s.log.Ctx(ctx).NoErrClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return false, err
+	}
+	nextPage, err := strconv.ParseInt(resp.Header.Get("x-next-page"), 10, 64)
+	if err != nil {
+		// if x-next-page is not valid, there is no next page
+		return false, nil
+	}
+	return int(nextPage) != page, nil
+}
+
+// DownloadLayout returns disable_architecture and file_extension parameters.
+func (d *downloads) DownloadLayout() (disableArchitecture bool, fileExtension string) {
+	return d.disableArchitecture, d.fileExtension
+}
+
 func (d *downloads) extension(arch string) string {
 	if d.disableArchitecture {
 		return d.fileExtension
@@ -14,35 +14,53 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"go.lstv.dev/goproxy/logger"
 	"go.lstv.dev/goproxy/source"
 	"go.lstv.dev/goproxy/util"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-const Type = "gitlab"
+const (
+	Type = "gitlab"
+
+	DefaultAccessCacheTTL = time.Minute
+
+	jobTokenUser = "gitlab-ci-token"
+)
 
 func init() {
 	source.Register(Type, New)
 }
 
 type Source struct {
-	log         logger.Logger
-	url         string
-	auth        string
-	insecureTLS bool
-	client      *http.Client
-	params      *params
+	log                logger.Logger
+	url                string
+	auth               string
+	insecureTLS        bool
+	forwardCredentials bool
+	tagsCacheTTL       time.Duration
+	client             *http.Client
+	params             *params
+	tags               *tagsCache
+	access             *accessCache
 }
 
 func New(config map[string]any) (source.Source, error) {
 	if config == nil {
 		return nil, errors.New("gitlab.New: expected url and auth")
 	}
+	if err := checkKeys(config, sourceConfigKeys); err != nil {
+		return nil, fmt.Errorf("gitlab.New: %w", err)
+	}
 	url, ok := config["url"].(string)
 	if !ok {
 		return nil, fmt.Errorf("gitlab.New: expected url as string instead of %T", config["url"])
@@ -51,19 +69,35 @@ func New(config map[string]any) (source.Source, error) {
 	if !ok {
 		return nil, fmt.Errorf("gitlab.New: expected auth as string instead of %T", config["auth"])
 	}
+	// name of source is passed by service, it labels metrics
+	name, _ := config["name"].(string)
 	allowInsecureTLS, _ := config["allow_insecure_tls"].(bool)
+	forwardCredentials, _ := config["forward_credentials"].(bool)
+	tagsCacheTTL, err := durationParam(config, "tags_cache_ttl", 0)
+	if err != nil {
+		return nil, fmt.Errorf("gitlab.New: %w", err)
+	}
+	accessCacheTTL, err := durationParam(config, "access_cache_ttl", DefaultAccessCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("gitlab.New: %w", err)
+	}
 	g := &Source{
 		log: logger.Type("gitlab.Source").With(
 			"url", url,
 		),
-		url:         url,
-		auth:        auth,
-		insecureTLS: allowInsecureTLS,
-		client:      &http.Client{},
-	}
+		url:                url,
+		auth:               auth,
+		insecureTLS:        allowInsecureTLS,
+		forwardCredentials: forwardCredentials,
+		tagsCacheTTL:       tagsCacheTTL,
+		client:             &http.Client{},
+		access:             newAccessCache(accessCacheTTL),
+	}
+	g.client.CheckRedirect = g.checkRedirect
 	if allowInsecureTLS {
 		g.allowInsecureTLS()
 	}
+	g.client.Transport = newInstrumentedTransport(g.client.Transport, name, url)
 	return g, nil
 }
 
@@ -101,11 +135,15 @@ func (s *Source) Parametrize(module string, params map[string]any) (source.Sourc
 			"tag_prefix", p.tagPrefix,
 			"version_dir", p.versionDir,
 		),
-		url:         s.url,
-		auth:        s.auth,
-		insecureTLS: s.insecureTLS,
-		client:      s.client,
-		params:      p,
+		url:                s.url,
+		auth:               s.auth,
+		insecureTLS:        s.insecureTLS,
+		forwardCredentials: s.forwardCredentials,
+		tagsCacheTTL:       s.tagsCacheTTL,
+		client:             s.client,
+		params:             p,
+		tags:               newTagsCache(s.tagsCacheTTL),
+		access:             s.access,
 	}, nil
 }
 
@@ -117,6 +155,7 @@ func (s *Source) ConfigPreview() (pairs []string) {
 		"dir", s.params.dir,
 		"tag_prefix", s.params.tagPrefix,
 		"insecure_tls", strconv.FormatBool(s.insecureTLS),
+		"forward_credentials", strconv.FormatBool(s.forwardCredentials),
 	}
 }
 
@@ -128,6 +167,138 @@ func (s *Source) ListVersions(ctx context.Context, major uint) ([]string, error)
 		log.Error("not parametrized source")
 		return nil, source.ErrNotParametrized
 	}
+	tags, err := s.listTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := []string(nil)
+	tagPrefixLength := len(s.params.tagPrefix)
+	for _, t := range tags {
+		version := t[tagPrefixLength:]
+		if v, err := util.ParseTagVersion(version); err != nil {
+			if isK8S(version) {
+				continue
+			}
+			log.Err(err).Debug("invalid tag version")
+		} else if v.Major == major || (v.Major == 0 && major == 1) {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+// MatchTag returns module version for tag pushed to specified project.
+func (s *Source) MatchTag(projectID int64, tag string) (version string, ok bool) {
+	if s.params == nil || s.params.projectID != projectID || !strings.HasPrefix(tag, s.params.tagPrefix) {
+		return "", false
+	}
+	version = tag[len(s.params.tagPrefix):]
+	if _, err := util.ParseTagVersion(version); err != nil {
+		return "", false
+	}
+	return version, true
+}
+
+// InvalidateCache drops cached tags of the project and verified access of client tokens.
+func (s *Source) InvalidateCache() {
+	if s.tags != nil {
+		s.tags.invalidate()
+	}
+	s.access.invalidate()
+}
+
+// CheckAccess verifies access of forwarded client credentials to the project.
+func (s *Source) CheckAccess(ctx context.Context) error {
+	if s.params == nil {
+		return source.ErrNotParametrized
+	}
+	return s.checkProjectAccess(ctx, s.params.projectID)
+}
+
+func (s *Source) checkProjectAccess(ctx context.Context, projectID int64) error {
+	if !s.forwardCredentials {
+		return nil
+	}
+	c, ok := source.CredentialsFromContext(ctx)
+	if !ok {
+		return source.ErrUnauthenticated
+	}
+	if s.access.allowed(c.Token, projectID) {
+		return nil
+	}
+	resp, err := s.doGetRequest(ctx, s.apiURL(fmt.Sprintf("projects/%d", projectID)))
+	if err != nil {
+		return fmt.Errorf("CheckAccess: request failed: %w", err)
+	}
+	defer s.log.NoErrClose(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		s.access.allow(c.Token, projectID)
+		return nil
+	case http.StatusUnauthorized:
+		return source.ErrUnauthenticated
+	case http.StatusForbidden, http.StatusNotFound:
+		return source.ErrAccessDenied
+	default:
+		return fmt.Errorf("CheckAccess: request failed: status code %d", resp.StatusCode)
+	}
+}
+
+// Check verifies the source token and for parametrized source also the project and that tag prefix matches any tag.
+func (s *Source) Check(ctx context.Context) error {
+	if s.params == nil {
+		user := struct{}{}
+		ok, err := s.getJSON(ctx, s.apiURL("user"), "PRIVATE-TOKEN", s.auth, &user)
+		if err != nil {
+			return fmt.Errorf("Check: request failed: %w", err)
+		}
+		if !ok {
+			return errors.New("Check: invalid token")
+		}
+		return nil
+	}
+	if err := s.checkProject(ctx, s.params.projectID); err != nil {
+		return err
+	}
+	tags, err := s.listTags(ctx)
+	if err != nil {
+		return fmt.Errorf("Check: %w", err)
+	}
+	for _, t := range tags {
+		if _, ok := s.MatchTag(s.params.projectID, t); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("Check: no tag matches tag prefix %q", s.params.tagPrefix+"v")
+}
+
+// checkProject verifies that the project is accessible with the source token.
+func (s *Source) checkProject(ctx context.Context, projectID int64) error {
+	resp, err := s.doGetRequestWithToken(ctx, s.apiURL(fmt.Sprintf("projects/%d", projectID)), "PRIVATE-TOKEN", s.auth)
+	if err != nil {
+		return fmt.Errorf("Check: request failed: %w", err)
+	}
+	defer s.log.NoErrClose(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return errors.New("Check: invalid token")
+	case http.StatusForbidden, http.StatusNotFound:
+		return fmt.Errorf("Check: project %d not found", projectID)
+	default:
+		return fmt.Errorf("Check: request failed: status code %d", resp.StatusCode)
+	}
+}
+
+func (s *Source) listTags(ctx context.Context) ([]string, error) {
+	log := s.log.Ctx(ctx).With(
+		"func", "listTags",
+	)
+	if tags, ok := s.tags.get(); ok {
+		log.Trace("tags cache hit")
+		return tags, nil
+	}
 	url := s.apiURL(fmt.Sprintf("projects/%d/repository/tags?search=^%sv",
 		s.params.projectID,
 		s.params.tagPrefix,
@@ -151,20 +322,12 @@ func (s *Source) ListVersions(ctx context.Context, major uint) ([]string, error)
 		log.Err(err).Debug("invalid response")
 		return nil, fmt.Errorf("ListVersions: invalid response: %w", err)
 	}
-	versions := []string(nil)
-	tagPrefixLength := len(s.params.tagPrefix)
+	tags := make([]string, 0, len(content))
 	for _, t := range content {
-		version := t.Name[tagPrefixLength:]
-		if v, err := util.ParseTagVersion(version); err != nil {
-			if isK8S(version) {
-				continue
-			}
-			log.Err(err).Debug("invalid tag version")
-		} else if v.Major == major || (v.Major == 0 && major == 1) {
-			versions = append(versions, version)
-		}
+		tags = append(tags, t.Name)
 	}
-	return versions, nil
+	s.tags.set(tags)
+	return tags, nil
 }
 
 func (s *Source) LatestVersion(ctx context.Context, major uint) (string, error) {
@@ -197,7 +360,13 @@ func (s *Source) LatestVersion(ctx context.Context, major uint) (string, error)
 	return latest.TagString(), nil
 }
 
-func (s *Source) DownloadModule(ctx context.Context, dir, version string) error {
+func (s *Source) DownloadModule(ctx context.Context, dir, version string) (err error) {
+	ctx, span := startSpan(ctx, "gitlab.DownloadModule",
+		attribute.String("version", version),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
 	c := logger.ContextWith(ctx,
 		"dir", dir,
 		"version", version,
@@ -246,14 +415,19 @@ func (s *Source) DownloadModule(ctx context.Context, dir, version string) error
 	}
 	if err := s.saveModule(c, dir, version, timestamp, tmpPath); err != nil {
 		log.Err(err).Debug("unable to save module")
+		return err
 	}
 	return nil
 }
 
 func (s *Source) ParametrizeDownloads(name, mode string, params map[string]any) (source.Downloads, error) {
-	if mode != "generic-packages" {
+	keys, ok := downloadsParamKeys[mode]
+	if !ok {
 		return nil, fmt.Errorf("ParametrizeDownloads: invalid mode %q", mode)
 	}
+	if err := checkKeys(params, keys); err != nil {
+		return nil, fmt.Errorf("ParametrizeDownloads: %w", err)
+	}
 	projectIDNumber, ok := params["project_id"].(json.Number)
 	if !ok {
 		return nil, fmt.Errorf("ParametrizeDownloads: expected project_id as json.Number instead of %T", params["project_id"])
@@ -262,6 +436,48 @@ func (s *Source) ParametrizeDownloads(name, mode string, params map[string]any)
 	if err != nil {
 		return nil, fmt.Errorf("ParametrizeDownloads: invalid project_id %w", err)
 	}
+	switch mode {
+	case "release-assets":
+		tagPrefix, err := stringParam(params, "tag_prefix", DefaultDownloadsTagPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("ParametrizeDownloads: %w", err)
+		}
+		assetName, err := stringParam(params, "asset_name", DefaultAssetName)
+		if err != nil {
+			return nil, fmt.Errorf("ParametrizeDownloads: %w", err)
+		}
+		return &releaseAssets{
+			Source:    s,
+			name:      name,
+			projectID: projectID,
+			tagPrefix: tagPrefix,
+			assetName: assetTemplate(assetName),
+		}, nil
+	case "job-artifacts":
+		tagPrefix, err := stringParam(params, "tag_prefix", DefaultDownloadsTagPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("ParametrizeDownloads: %w", err)
+		}
+		job, err := stringParam(params, "job", "")
+		if err != nil {
+			return nil, fmt.Errorf("ParametrizeDownloads: %w", err)
+		}
+		artifactPath, err := stringParam(params, "artifact_path", "")
+		if err != nil {
+			return nil, fmt.Errorf("ParametrizeDownloads: %w", err)
+		}
+		if job == "" || artifactPath == "" {
+			return nil, errors.New("ParametrizeDownloads: expected job and artifact_path")
+		}
+		return &jobArtifacts{
+			Source:       s,
+			name:         name,
+			projectID:    projectID,
+			tagPrefix:    tagPrefix,
+			job:          job,
+			artifactPath: assetTemplate(strings.TrimPrefix(artifactPath, "/")),
+		}, nil
+	}
 	packageName := name // default package name
 	if packageNameInterface, ok := params["package_name"]; ok {
 		// packageName must be variable from outer scope
@@ -281,16 +497,163 @@ func (s *Source) ParametrizeDownloads(name, mode string, params map[string]any)
 	}, nil
 }
 
+// Authenticate returns user owning the personal access token or the job token.
+// Scopes are listed for personal access tokens, job tokens have no scopes.
+func (s *Source) Authenticate(ctx context.Context, token string) (source.TokenOwner, error) {
+	log := s.log.Ctx(ctx).With(
+		"func", "Authenticate",
+	)
+	result := struct {
+		Username string `json:"username"`
+		User     struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	}{}
+	for _, c := range []struct {
+		header string
+		path   string
+	}{
+		{header: "PRIVATE-TOKEN", path: "user"},
+		{header: "JOB-TOKEN", path: "job"},
+	} {
+		ok, err := s.getJSON(ctx, s.apiURL(c.path), c.header, token, &result)
+		if err != nil {
+			log.Err(err).With(
+				"header", c.header,
+			).Debug("request failed")
+			return source.TokenOwner{}, fmt.Errorf("Authenticate: %w", err)
+		}
+		if !ok {
+			continue
+		}
+		if result.Username != "" {
+			return source.TokenOwner{
+				User:   result.Username,
+				Scopes: s.tokenScopes(ctx, token),
+			}, nil
+		}
+		if result.User.Username != "" {
+			return source.TokenOwner{
+				User: result.User.Username,
+			}, nil
+		}
+	}
+	return source.TokenOwner{}, source.ErrUnauthenticated
+}
+
+// tokenScopes returns sorted scopes of personal access token.
+// Scopes are not known if GitLab does not support listing them (before 15.5), nil is returned.
+func (s *Source) tokenScopes(ctx context.Context, token string) []string {
+	result := struct {
+		Scopes []string `json:"scopes"`
+	}{}
+	ok, err := s.getJSON(ctx, s.apiURL("personal_access_tokens/self"), "PRIVATE-TOKEN", token, &result)
+	if err != nil || !ok {
+		s.log.Ctx(ctx).Err(err).With(
+			"func", "tokenScopes",
+		).Debug("unable to get scopes of token")
+		return nil
+	}
+	sort.Strings(result.Scopes)
+	return result.Scopes
+}
+
+// getJSON decodes response of GET request authorized by specified header.
+// If token is not valid, false is returned.
+func (s *Source) getJSON(ctx context.Context, url, header, token string, v any) (ok bool, err error) {
+	resp, err := s.doGetRequestWithToken(ctx, url, header, token)
+	if err != nil {
+		return false, err
+	}
+	defer s.log.NoErrClose(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, json.NewDecoder(resp.Body).Decode(v)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+}
+
+// doGetRequest sends GET request authorized by client credentials if forwarding is enabled.
+// Otherwise, or if client credentials are not present, source token is used.
 func (s *Source) doGetRequest(ctx context.Context, url string) (*http.Response, error) {
+	return s.doRequest(ctx, http.MethodGet, url, nil)
+}
+
+// doRequest sends request with additional headers authorized the same way as doGetRequest.
+// Requests to other hosts than GitLab are not authorized.
+func (s *Source) doRequest(ctx context.Context, method, url string, header http.Header) (*http.Response, error) {
+	authHeader, token := "PRIVATE-TOKEN", s.auth
+	if s.forwardCredentials {
+		if c, ok := source.CredentialsFromContext(ctx); ok {
+			authHeader, token = credentialsHeader(c)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// credentials are not sent to other hosts, e.g. external links of releases,
+	// redirects to other hosts are stripped of credentials by checkRedirect
+	if s.isGitlabHost(req.URL) {
+		req.Header.Set(authHeader, token)
+	}
+	return s.client.Do(req)
+}
+
+// isGitlabHost returns whether URL is at the host of GitLab.
+func (s *Source) isGitlabHost(u *neturl.URL) bool {
+	gitlab, err := neturl.Parse(s.url)
+	return err == nil && gitlab.Host == u.Host
+}
+
+// checkRedirect removes credentials from redirects to other hosts than GitLab,
+// e.g. from direct asset URL of release link to its external URL.
+// The client removes only Authorization and Cookie headers by default.
+func (s *Source) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !s.isGitlabHost(req.URL) {
+		for _, h := range []string{"PRIVATE-TOKEN", "JOB-TOKEN", "Authorization"} {
+			req.Header.Del(h)
+		}
+	}
+	return nil
+}
+
+func credentialsHeader(c source.Credentials) (header, token string) {
+	switch {
+	case c.Bearer:
+		return "Authorization", "Bearer " + c.Token
+	case c.User == jobTokenUser:
+		return "JOB-TOKEN", c.Token
+	default:
+		return "PRIVATE-TOKEN", c.Token
+	}
+}
+
+func (s *Source) doGetRequestWithToken(ctx context.Context, url, header, token string) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("PRIVATE-TOKEN", s.auth)
+	req.Header.Set(header, token)
 	return s.client.Do(req)
 }
 
 func (s *Source) findCommit(ctx context.Context, version string) (commit, timestamp string, err error) {
+	ctx, span := startSpan(ctx, "gitlab.findCommit",
+		attribute.String("version", version),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
 	tag := s.params.tagPrefix + version
 	url := s.apiURL(fmt.Sprintf("projects/%d/repository/tags/%s",
 		s.params.projectID,
@@ -319,7 +682,13 @@ func (s *Source) findCommit(ctx context.Context, version string) (commit, timest
 	return obj.Commit.ID, obj.Commit.CreatedAt, nil
 }
 
-func (s *Source) fetchArchive(ctx context.Context, file, commit string) error {
+func (s *Source) fetchArchive(ctx context.Context, file, commit string) (err error) {
+	ctx, span := startSpan(ctx, "gitlab.fetchArchive",
+		attribute.String("commit", commit),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
 	url := s.apiURL(fmt.Sprintf("/projects/%d/repository/archive.zip?sha=%s",
 		s.params.projectID,
 		commit,
@@ -426,7 +795,11 @@ func (s *Source) writeInfo(w io.Writer, version, timestamp string) error {
 	return json.NewEncoder(w).Encode(info)
 }
 
-func (s *Source) writeZip(ctx context.Context, zipW, modW io.Writer, version, archivePath string) error {
+func (s *Source) writeZip(ctx context.Context, zipW, modW io.Writer, version, archivePath string) (err error) {
+	ctx, span := startSpan(ctx, "gitlab.writeZip")
+	defer func() {
+		endSpan(span, err)
+	}()
 	log := s.log.Ctx(ctx).With(
 		"func", "writeZip",
 	)
@@ -446,6 +819,10 @@ func (s *Source) writeZip(ctx context.Context, zipW, modW io.Writer, version, ar
 	w := zip.NewWriter(zipW)
 	defer log.NoErrClose(w)
 	for _, f := range r.File {
+		// download is cancelled e.g. at shutdown
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		name := util.TrimName(dir, f.Name)
 		l := log.With(
 			"name", name,
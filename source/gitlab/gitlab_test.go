@@ -0,0 +1,639 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/util"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestSource(t *testing.T, handler http.HandlerFunc, config map[string]any) (*Source, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	config["url"] = server.URL
+	config["auth"] = "source-token"
+	s, err := New(config)
+	require.NoError(t, err)
+	p, err := s.Parametrize("example.com/a", map[string]any{
+		"project_id": json.Number("42"),
+	})
+	require.NoError(t, err)
+	return p.(*Source), &requests
+}
+
+func Test_Source_CheckAccess(t *testing.T) {
+	s, requests := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v4/projects/42", r.URL.Path)
+		switch r.Header.Get("PRIVATE-TOKEN") {
+		case "member":
+			w.WriteHeader(http.StatusOK)
+		case "guest":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}, map[string]any{
+		"forward_credentials": true,
+	})
+
+	ctx := context.Background()
+	assert.ErrorIs(t, s.CheckAccess(ctx), source.ErrUnauthenticated)
+	member := source.ContextWithCredentials(ctx, source.Credentials{User: "user", Token: "member"})
+	assert.NoError(t, s.CheckAccess(member))
+	assert.NoError(t, s.CheckAccess(member))
+	assert.Equal(t, 1, *requests, "access should be cached")
+	guest := source.ContextWithCredentials(ctx, source.Credentials{User: "user", Token: "guest"})
+	assert.ErrorIs(t, s.CheckAccess(guest), source.ErrAccessDenied)
+	invalid := source.ContextWithCredentials(ctx, source.Credentials{User: "user", Token: "invalid"})
+	assert.ErrorIs(t, s.CheckAccess(invalid), source.ErrUnauthenticated)
+}
+
+func Test_Source_CheckAccess_disabled(t *testing.T) {
+	s, requests := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}, map[string]any{})
+	assert.NoError(t, s.CheckAccess(context.Background()))
+	assert.Equal(t, 0, *requests)
+}
+
+func Test_Source_doGetRequest(t *testing.T) {
+	headers := http.Header{}
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+	}, map[string]any{
+		"forward_credentials": true,
+	})
+
+	cases := []struct {
+		credentials *source.Credentials
+		header      string
+		value       string
+	}{
+		{credentials: nil, header: "PRIVATE-TOKEN", value: "source-token"},
+		{credentials: &source.Credentials{User: "user", Token: "pat"}, header: "PRIVATE-TOKEN", value: "pat"},
+		{credentials: &source.Credentials{User: "gitlab-ci-token", Token: "job"}, header: "JOB-TOKEN", value: "job"},
+		{credentials: &source.Credentials{Token: "oauth", Bearer: true}, header: "Authorization", value: "Bearer oauth"},
+	}
+	for i, c := range cases {
+		ctx := context.Background()
+		if c.credentials != nil {
+			ctx = source.ContextWithCredentials(ctx, *c.credentials)
+		}
+		resp, err := s.doGetRequest(ctx, s.apiURL("projects/42"))
+		require.NoError(t, err, "case %d", i)
+		assert.NoError(t, resp.Body.Close())
+		assert.Equal(t, c.value, headers.Get(c.header), "case %d", i)
+	}
+}
+
+func Test_Source_MatchTag(t *testing.T) {
+	s, _ := newTestSource(t, nil, map[string]any{})
+	s.params.tagPrefix = "a-"
+	version, ok := s.MatchTag(42, "a-v1.2.3")
+	assert.True(t, ok)
+	assert.Equal(t, "v1.2.3", version)
+	_, ok = s.MatchTag(43, "a-v1.2.3")
+	assert.False(t, ok)
+	_, ok = s.MatchTag(42, "b-v1.2.3")
+	assert.False(t, ok)
+	_, ok = s.MatchTag(42, "a-1.2.3")
+	assert.False(t, ok)
+}
+
+func Test_unknownParameters(t *testing.T) {
+	_, err := New(map[string]any{"url": "https://gitlab.example.com/", "auth": "token", "tags_cache_tl": "5m"})
+	assert.ErrorContains(t, err, `unknown parameters ["tags_cache_tl"]`)
+
+	s, err := New(map[string]any{"url": "https://gitlab.example.com/", "auth": "token"})
+	require.NoError(t, err)
+	_, err = s.Parametrize("example.com/a", map[string]any{"project_id": json.Number("1"), "tag_prefx": "a-"})
+	assert.ErrorContains(t, err, `unknown parameters ["tag_prefx"]`)
+	_, err = s.(*Source).ParametrizeDownloads("tool", "generic-packages", map[string]any{"project_id": json.Number("1"), "file_ext": ".zip"})
+	assert.ErrorContains(t, err, `unknown parameters ["file_ext"]`)
+}
+
+func Test_Source_Check(t *testing.T) {
+	tags := `[{"name":"a-v1.0.0"}]`
+	project := http.StatusOK
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "source-token", r.Header.Get("PRIVATE-TOKEN"))
+		switch r.URL.Path {
+		case "/api/v4/projects/42":
+			w.WriteHeader(project)
+		case "/api/v4/projects/42/repository/tags":
+			_, _ = w.Write([]byte(tags))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, map[string]any{})
+	s.params.tagPrefix = "a-"
+
+	ctx := context.Background()
+	assert.NoError(t, s.Check(ctx))
+	tags = `[{"name":"a-v1.0.0-invalid.version!"}]`
+	assert.ErrorContains(t, s.Check(ctx), `no tag matches tag prefix "a-v"`)
+	project = http.StatusNotFound
+	assert.ErrorContains(t, s.Check(ctx), "project 42 not found")
+	project = http.StatusUnauthorized
+	assert.ErrorContains(t, s.Check(ctx), "invalid token")
+}
+
+func Test_Source_Check_token(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v4/user", r.URL.Path)
+		if r.Header.Get("PRIVATE-TOKEN") != "valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"username":"bot"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	for i, c := range []struct {
+		auth string
+		err  bool
+	}{
+		{auth: "valid"},
+		{auth: "invalid", err: true},
+	} {
+		s, err := New(map[string]any{"url": server.URL, "auth": c.auth})
+		require.NoError(t, err, "case %d", i)
+		err = s.(source.Checker).Check(context.Background())
+		if c.err {
+			assert.ErrorContains(t, err, "invalid token", "case %d", i)
+		} else {
+			assert.NoError(t, err, "case %d", i)
+		}
+	}
+}
+
+func Test_instrumentedTransport(t *testing.T) {
+	status := http.StatusOK
+	traceparent := ""
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(status)
+	}, map[string]any{"name": "gitlab-test"})
+	// metrics are labeled by source name
+	assert.Equal(t, "gitlab-test", s.client.Transport.(*instrumentedTransport).source)
+	errors := apiRequestErrors.WithLabelValues(Type, "gitlab-test")
+
+	resp, err := s.doGetRequest(context.Background(), s.apiURL("user"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, 0.0, testutil.ToFloat64(errors))
+	status = http.StatusBadGateway
+	resp, err = s.doGetRequest(context.Background(), s.apiURL("user"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, 1.0, testutil.ToFloat64(errors))
+	assert.Equal(t, "", traceparent)
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	ctx, span := startSpan(context.Background(), "test")
+	defer span.End()
+	resp, err = s.doGetRequest(ctx, s.apiURL("user"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Contains(t, traceparent, "-"+span.SpanContext().TraceID().String()+"-")
+}
+
+func Test_downloads_WriteDownload(t *testing.T) {
+	content := "0123456789"
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v4/projects/1/packages/generic/tool/1.0.0/tool-1.0.0-linux-amd64", r.URL.Path)
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}, map[string]any{})
+	d, err := s.ParametrizeDownloads("tool", "generic-packages", map[string]any{"project_id": json.Number("1")})
+	require.NoError(t, err)
+	v, err := util.ParseVersion("1.0.0")
+	require.NoError(t, err)
+
+	cases := []struct {
+		Method   string
+		Range    string
+		Status   int
+		Length   string
+		Body     string
+		Response string
+	}{
+		{Method: http.MethodGet, Status: http.StatusOK, Length: "10", Body: content},
+		{Method: http.MethodHead, Status: http.StatusOK, Length: "10", Body: ""},
+		{Method: http.MethodGet, Range: "bytes=2-4", Status: http.StatusPartialContent, Length: "3", Body: "234", Response: "bytes 2-4/10"},
+		{Method: http.MethodGet, Range: "bytes=20-", Status: http.StatusRequestedRangeNotSatisfiable},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest(c.Method, "/dl/tool/v1.0.0/linux-amd64", http.NoBody)
+		if c.Range != "" {
+			req.Header.Set("Range", c.Range)
+		}
+		w := httptest.NewRecorder()
+		d.WriteDownload(context.Background(), w, req, v, "linux-amd64")
+		assert.Equalf(t, c.Status, w.Code, "case %d", i)
+		if c.Status == http.StatusRequestedRangeNotSatisfiable {
+			continue
+		}
+		assert.Equalf(t, c.Length, w.Header().Get("Content-Length"), "case %d", i)
+		assert.Equalf(t, c.Body, w.Body.String(), "case %d", i)
+		assert.Equalf(t, c.Response, w.Header().Get("Content-Range"), "case %d", i)
+		assert.Equalf(t, `"abc"`, w.Header().Get("ETag"), "case %d", i)
+	}
+}
+
+func Test_downloads_DownloadFiles(t *testing.T) {
+	fileRequests := 0
+	s, requests := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/package_files") {
+			fileRequests++
+		}
+		switch r.URL.Path {
+		case "/api/v4/projects/1/packages":
+			if r.URL.Query().Get("package_version") == "" {
+				_, _ = w.Write([]byte(`[{"id":11,"version":"1.0.0","created_at":"2022-03-17T10:00:00Z"},{"id":12,"version":"1.1.0","created_at":"2022-03-18T10:00:00Z"}]`))
+				return
+			}
+			if r.URL.Query().Get("package_version") != "1.0.0" {
+				_, _ = w.Write([]byte(`[]`))
+				return
+			}
+			if r.URL.Query().Get("page") == "1" {
+				w.Header().Set("x-next-page", "2")
+				_, _ = w.Write([]byte(`[{"id":10,"version":"0.9.0"}]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"id":11,"version":"1.0.0"}]`))
+		case "/api/v4/projects/1/packages/11/package_files":
+			_, _ = w.Write([]byte(`[
+				{"file_name":"tool-1.0.0-linux-amd64.tar.gz"},
+				{"file_name":"tool-1.0.0-linux-amd64.tar.gz.sig"},
+				{"file_name":"tool-1.0.0-darwin-arm64.tar.gz"},
+				{"file_name":"tool-1.0.0-darwin-arm64.tar.gz"},
+				{"file_name":"SHA256SUMS"}
+			]`))
+		case "/api/v4/projects/1/packages/12/package_files":
+			_, _ = w.Write([]byte(`[{"file_name":"tool-1.1.0-linux-amd64.tar.gz"}]`))
+		case "/api/v4/projects/1/packages/generic/tool/1.0.0/tool-1.0.0-linux-amd64.tar.gz.sig":
+			_, _ = w.Write([]byte("signature"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, map[string]any{})
+	d, err := s.ParametrizeDownloads("tool", "generic-packages", map[string]any{
+		"project_id":     json.Number("1"),
+		"file_extension": ".tar.gz",
+	})
+	require.NoError(t, err)
+	v, err := util.ParseVersion("1.0.0")
+	require.NoError(t, err)
+	files := d.(source.DownloadFiles)
+
+	archs, err := files.DownloadArchitectures(context.Background(), v)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"darwin-arm64", "linux-amd64"}, archs)
+
+	w := httptest.NewRecorder()
+	files.WriteDownloadFile(context.Background(), w, httptest.NewRequest(http.MethodGet, "/", http.NoBody), v, "linux-amd64", ".sig")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "signature", w.Body.String())
+
+	w = httptest.NewRecorder()
+	files.WriteDownloadFile(context.Background(), w, httptest.NewRequest(http.MethodGet, "/", http.NoBody), v, "linux-amd64", ".asc")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	_, err = files.DownloadArchitectures(context.Background(), util.Version{Major: 2})
+	assert.True(t, source.IsVersionNotFound(err))
+
+	// versions are listed without requests of package files
+	fileRequests = 0
+	versions, err := d.ListDownloadVersions(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []source.DownloadVersion{
+		{Version: util.Version{Major: 1, Minor: 1}, Published: time.Date(2022, 3, 18, 10, 0, 0, 0, time.UTC), ID: 12},
+		{Version: util.Version{Major: 1}, Published: time.Date(2022, 3, 17, 10, 0, 0, 0, time.UTC), ID: 11},
+	}, versions)
+	assert.Equal(t, 0, fileRequests)
+
+	// architectures of listed versions need only requests of package files
+	*requests = 0
+	for i, expected := range [][]string{{"linux-amd64"}, {"darwin-arm64", "linux-amd64"}} {
+		archs, err := d.(source.ListedArchitectures).ListedArchitectures(context.Background(), versions[i])
+		require.NoError(t, err)
+		assert.Equal(t, expected, archs, "case %d", i)
+	}
+	assert.Equal(t, 2, *requests)
+}
+
+func Test_assetTemplate(t *testing.T) {
+	v := util.Version{Major: 1, Minor: 2}
+	cases := []struct {
+		Template string
+		Asset    string
+		Arch     string
+		OK       bool
+	}{
+		{Template: DefaultAssetName, Asset: "tool-1.2.0-linux-amd64", Arch: "linux-amd64", OK: true},
+		{Template: DefaultAssetName, Asset: "tool-1.2.0-linux-amd64.sig", OK: false},
+		{Template: DefaultAssetName, Asset: "tool-1.1.0-linux-amd64", OK: false},
+		{Template: "{name}_{version}_{arch}.tar.gz", Asset: "tool_1.2.0_darwin-arm64.tar.gz", Arch: "darwin-arm64", OK: true},
+		{Template: "{arch}/{name}-{arch}", Asset: "linux-amd64/tool-linux-amd64", Arch: "linux-amd64", OK: true},
+		{Template: "{arch}/{name}-{arch}", Asset: "linux-amd64/tool-linux-arm64", OK: false},
+		{Template: "bin/{name}", Asset: "bin/tool", OK: true},
+	}
+	for i, c := range cases {
+		arch, ok := assetTemplate(c.Template).architecture("tool", v, c.Asset)
+		assert.Equalf(t, c.OK, ok, "case %d", i)
+		assert.Equalf(t, c.Arch, arch, "case %d", i)
+		if c.OK {
+			assert.Equalf(t, c.Asset, assetTemplate(c.Template).render("tool", v, c.Arch), "case %d", i)
+		}
+	}
+
+	disableArchitecture, fileExtension := (&releaseAssets{assetName: "{name}-{version}-{arch}.tar.gz"}).DownloadLayout()
+	assert.False(t, disableArchitecture)
+	assert.Equal(t, ".tar.gz", fileExtension)
+	disableArchitecture, fileExtension = (&jobArtifacts{artifactPath: "bin/{name}"}).DownloadLayout()
+	assert.True(t, disableArchitecture)
+	assert.Equal(t, "", fileExtension)
+}
+
+func Test_releaseAssets(t *testing.T) {
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/1/releases":
+			_, _ = w.Write([]byte(`[
+				{"tag_name":"v1.1.0","released_at":"2022-03-18T10:00:00Z","assets":{"links":[{"name":"tool-1.1.0-linux-amd64"}]}},
+				{"tag_name":"other","released_at":"2022-03-18T11:00:00Z"},
+				{"tag_name":"v1.0.0","released_at":"2022-03-17T10:00:00Z","assets":{"links":[
+					{"name":"tool-1.0.0-linux-amd64"},{"name":"tool-1.0.0-darwin-arm64"},{"name":"tool-1.0.0-darwin-arm64.sig"}
+				]}}
+			]`))
+		case "/api/v4/projects/1/releases/v1.0.0":
+			_, _ = w.Write([]byte(`{"tag_name":"v1.0.0","assets":{"links":[
+				{"name":"tool-1.0.0-linux-amd64","url":"http://` + r.Host + `/uploads/tool-linux-amd64"},
+				{"name":"tool-1.0.0-darwin-arm64","url":"http://` + r.Host + `/other","direct_asset_url":"http://` + r.Host + `/uploads/tool-darwin-arm64"}
+			]}}`))
+		case "/uploads/tool-linux-amd64":
+			assert.Equal(t, "source-token", r.Header.Get("PRIVATE-TOKEN"))
+			_, _ = w.Write([]byte("linux"))
+		case "/uploads/tool-darwin-arm64":
+			_, _ = w.Write([]byte("darwin"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, map[string]any{})
+	_, err := s.ParametrizeDownloads("tool", "release-assets", map[string]any{"project_id": json.Number("1"), "job": "build"})
+	assert.Error(t, err)
+	d, err := s.ParametrizeDownloads("tool", "release-assets", map[string]any{"project_id": json.Number("1")})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	latest, err := d.LatestDownloadVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, util.Version{Major: 1, Minor: 1}, latest)
+
+	versions, err := d.ListDownloadVersions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []source.DownloadVersion{
+		{Version: util.Version{Major: 1, Minor: 1}, Architectures: []string{"linux-amd64"}, Published: time.Date(2022, 3, 18, 10, 0, 0, 0, time.UTC)},
+		{Version: util.Version{Major: 1}, Architectures: []string{"darwin-arm64", "linux-amd64"}, Published: time.Date(2022, 3, 17, 10, 0, 0, 0, time.UTC)},
+	}, versions)
+
+	v := util.Version{Major: 1}
+	archs, err := d.(source.DownloadFiles).DownloadArchitectures(ctx, v)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"darwin-arm64", "linux-amd64"}, archs)
+
+	cases := []struct {
+		Arch   string
+		Status int
+		Body   string
+	}{
+		{Arch: "linux-amd64", Status: http.StatusOK, Body: "linux"},
+		{Arch: "darwin-arm64", Status: http.StatusOK, Body: "darwin"},
+		{Arch: "windows-amd64", Status: http.StatusNotFound},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		d.WriteDownload(ctx, w, httptest.NewRequest(http.MethodGet, "/", http.NoBody), v, c.Arch)
+		assert.Equalf(t, c.Status, w.Code, "case %d", i)
+		if c.Status == http.StatusOK {
+			assert.Equalf(t, c.Body, w.Body.String(), "case %d", i)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	d.WriteDownload(ctx, w, httptest.NewRequest(http.MethodGet, "/", http.NoBody), util.Version{Major: 2}, "linux-amd64")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_jobArtifacts(t *testing.T) {
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/1/repository/tags":
+			if r.URL.Query().Get("page") == "1" {
+				w.Header().Set("x-next-page", "2")
+				_, _ = w.Write([]byte(`[{"name":"release/1.0.0","commit":{"created_at":"2022-03-17T10:00:00Z"}},{"name":"v9.0.0"}]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"name":"release/1.1.0","commit":{"created_at":"2022-03-18T10:00:00Z"}}]`))
+		case "/api/v4/projects/1/jobs/artifacts/release%2F1.0.0/raw/dist/linux-amd64/tool":
+			assert.Equal(t, "build binaries", r.URL.Query().Get("job"))
+			_, _ = w.Write([]byte("binary"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, map[string]any{})
+	_, err := s.ParametrizeDownloads("tool", "job-artifacts", map[string]any{"project_id": json.Number("1"), "job": "build"})
+	assert.Error(t, err)
+	d, err := s.ParametrizeDownloads("tool", "job-artifacts", map[string]any{
+		"project_id":    json.Number("1"),
+		"tag_prefix":    "release/",
+		"job":           "build binaries",
+		"artifact_path": "/dist/{arch}/{name}",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	versions, err := d.ListDownloadVersions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []source.DownloadVersion{
+		{Version: util.Version{Major: 1, Minor: 1}, Published: time.Date(2022, 3, 18, 10, 0, 0, 0, time.UTC)},
+		{Version: util.Version{Major: 1}, Published: time.Date(2022, 3, 17, 10, 0, 0, 0, time.UTC)},
+	}, versions)
+
+	latest, err := d.LatestDownloadVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, util.Version{Major: 1, Minor: 1}, latest)
+
+	w := httptest.NewRecorder()
+	d.WriteDownload(ctx, w, httptest.NewRequest(http.MethodGet, "/", http.NoBody), util.Version{Major: 1}, "linux-amd64")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "binary", w.Body.String())
+
+	w = httptest.NewRecorder()
+	d.WriteDownload(ctx, w, httptest.NewRequest(http.MethodGet, "/", http.NoBody), util.Version{Major: 1}, "linux-arm64")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_Source_checkRedirect(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{"PRIVATE-TOKEN", "JOB-TOKEN", "Authorization"} {
+			assert.Emptyf(t, r.Header.Get(h), "header %s", h)
+		}
+		_, _ = w.Write([]byte("external"))
+	}))
+	t.Cleanup(external.Close)
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/1/releases/v1.0.0":
+			_, _ = w.Write([]byte(`{"tag_name":"v1.0.0","assets":{"links":[
+				{"name":"tool-1.0.0-linux-amd64","direct_asset_url":"http://` + r.Host + `/-/releases/v1.0.0/downloads/tool"}
+			]}}`))
+		case "/-/releases/v1.0.0/downloads/tool":
+			assert.NotEmpty(t, r.Header.Get("PRIVATE-TOKEN")+r.Header.Get("JOB-TOKEN")+r.Header.Get("Authorization"))
+			http.Redirect(w, r, external.URL+"/tool", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}, map[string]any{
+		"forward_credentials": true,
+	})
+	d, err := s.ParametrizeDownloads("tool", "release-assets", map[string]any{"project_id": json.Number("1")})
+	require.NoError(t, err)
+
+	for i, c := range []source.Credentials{
+		{},
+		{User: "user", Token: "private"},
+		{User: jobTokenUser, Token: "job"},
+		{Token: "bearer", Bearer: true},
+	} {
+		ctx := context.Background()
+		if c.Token != "" {
+			ctx = source.ContextWithCredentials(ctx, c)
+		}
+		w := httptest.NewRecorder()
+		d.WriteDownload(ctx, w, httptest.NewRequest(http.MethodGet, "/", http.NoBody), util.Version{Major: 1}, "linux-amd64")
+		assert.Equalf(t, http.StatusOK, w.Code, "case %d", i)
+		assert.Equalf(t, "external", w.Body.String(), "case %d", i)
+	}
+}
+
+// abortRecorder is response recorder implementing source.DownloadAborter.
+type abortRecorder struct {
+	*httptest.ResponseRecorder
+	err error
+}
+
+func (r *abortRecorder) AbortDownload(err error) {
+	r.err = err
+}
+
+func Test_Source_writeFile_truncated(t *testing.T) {
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		// connection is closed after the body shorter than Content-Length
+		w.Header().Set("Content-Length", "10")
+		_, _ = w.Write([]byte("01234"))
+	}, map[string]any{})
+	w := &abortRecorder{ResponseRecorder: httptest.NewRecorder()}
+	s.writeFile(context.Background(), w, httptest.NewRequest(http.MethodGet, "/", http.NoBody), s.apiURL("file"))
+	assert.Error(t, w.err)
+	assert.Equal(t, "10", w.Header().Get("Content-Length"))
+}
+
+func Test_Source_Authenticate(t *testing.T) {
+	scopes := true
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v4/user" && r.Header.Get("PRIVATE-TOKEN") == "glpat-valid":
+			_, _ = w.Write([]byte(`{"username":"alice"}`))
+		case r.URL.Path == "/api/v4/personal_access_tokens/self" && scopes:
+			_, _ = w.Write([]byte(`{"scopes":["read_registry","read_api"]}`))
+		case r.URL.Path == "/api/v4/personal_access_tokens/self":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/api/v4/job" && r.Header.Get("JOB-TOKEN") == "job-valid":
+			_, _ = w.Write([]byte(`{"user":{"username":"bob"}}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}, map[string]any{})
+
+	owner, err := s.Authenticate(context.Background(), "glpat-valid")
+	assert.NoError(t, err)
+	assert.Equal(t, source.TokenOwner{User: "alice", Scopes: []string{"read_api", "read_registry"}}, owner)
+
+	// scopes are not known by older GitLab
+	scopes = false
+	owner, err = s.Authenticate(context.Background(), "glpat-valid")
+	assert.NoError(t, err)
+	assert.Equal(t, source.TokenOwner{User: "alice"}, owner)
+
+	owner, err = s.Authenticate(context.Background(), "job-valid")
+	assert.NoError(t, err)
+	assert.Equal(t, source.TokenOwner{User: "bob"}, owner)
+
+	_, err = s.Authenticate(context.Background(), "invalid")
+	assert.ErrorIs(t, err, source.ErrUnauthenticated)
+}
+
+func Test_downloads_LatestDownloadVersion(t *testing.T) {
+	status := http.StatusOK
+	s, _ := newTestSource(t, func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		switch r.URL.Query().Get("package_name") {
+		case "tool-package":
+			_, _ = w.Write([]byte(`[{"id":1,"version":"1.0.0"},{"id":2,"version":"1.2.0"}]`))
+		default:
+			_, _ = w.Write([]byte(`[{"id":3,"version":"9.0.0"}]`))
+		}
+	}, map[string]any{})
+	d, err := s.ParametrizeDownloads("tool", "generic-packages", map[string]any{
+		"project_id":   json.Number("1"),
+		"package_name": "tool-package",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// latest version and list of versions are resolved by the same package
+	latest, err := d.LatestDownloadVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, util.Version{Major: 1, Minor: 2}, latest)
+	versions, err := d.ListDownloadVersions(ctx)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, latest, versions[0].Version)
+
+	status = http.StatusUnauthorized
+	_, err = d.LatestDownloadVersion(ctx)
+	assert.ErrorContains(t, err, "401")
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "go.lstv.dev/goproxy/source/gitlab"
+
+var (
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "goproxy_source_request_duration_seconds",
+		Help:    "Duration of requests to source API by configured source name and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"type", "source", "code"})
+	apiRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goproxy_source_request_errors_total",
+		Help: "Count of failed requests to source API (transport errors and 5xx) by configured source name.",
+	}, []string{"type", "source"})
+)
+
+// instrumentedTransport records metrics and client spans of requests to GitLab API.
+type instrumentedTransport struct {
+	next   http.RoundTripper
+	host   string
+	source string // configured name of source, metrics label
+}
+
+// newInstrumentedTransport returns transport labeling metrics by source name, URL host is used if name is empty.
+func newInstrumentedTransport(next http.RoundTripper, name, sourceURL string) *instrumentedTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	host := sourceURL
+	if u, err := url.Parse(sourceURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	if name == "" {
+		name = host
+	}
+	return &instrumentedTransport{
+		next:   next,
+		host:   host,
+		source: name,
+	}
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := otel.Tracer(tracerName).Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("net.peer.name", t.host),
+		),
+	)
+	if span.SpanContext().IsValid() {
+		// request must not be modified by transport
+		req = req.Clone(ctx)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	spanErr := err
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			spanErr = errors.New("status code " + code)
+		}
+	}
+	endSpan(span, spanErr)
+	apiRequestDuration.WithLabelValues(Type, t.source, code).Observe(time.Since(start).Seconds())
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		apiRequestErrors.WithLabelValues(Type, t.source).Inc()
+	}
+	return resp, err
+}
+
+// startSpan starts span of the default tracer provider, it is no-op if tracing is disabled.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan ends the span, non-nil err marks the span as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -8,10 +8,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"go.lstv.dev/goproxy/util"
 )
 
+var (
+	sourceConfigKeys   = []string{"name", "url", "auth", "allow_insecure_tls", "tags_cache_ttl", "forward_credentials", "access_cache_ttl"}
+	moduleParamKeys    = []string{"project_id", "dir", "tag_prefix", "version_dir"}
+	downloadsParamKeys = map[string][]string{
+		"generic-packages": {"project_id", "package_name", "disable_architecture", "file_extension"},
+		"release-assets":   {"project_id", "tag_prefix", "asset_name"},
+		"job-artifacts":    {"project_id", "tag_prefix", "job", "artifact_path"},
+	}
+)
+
 type params struct {
 	module     string
 	projectID  int64
@@ -24,6 +36,9 @@ func newParams(module string, p map[string]any) (*params, error) {
 	if p == nil {
 		return nil, errors.New("newGitlabParams: expected project_id")
 	}
+	if err := checkKeys(p, moduleParamKeys); err != nil {
+		return nil, fmt.Errorf("newGitlabParams: %w", err)
+	}
 	projectIDNumber, ok := p["project_id"].(json.Number)
 	if !ok {
 		return nil, fmt.Errorf("newGitlabParams: expected project_id as json.Number instead of %T", p["project_id"])
@@ -43,3 +58,55 @@ func newParams(module string, p map[string]any) (*params, error) {
 		versionDir: versionDir,
 	}, nil
 }
+
+// durationParam returns duration parsed from string parameter or default value if parameter is missing.
+func durationParam(p map[string]any, key string, defaultValue time.Duration) (time.Duration, error) {
+	v, ok := p[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("expected %s as string instead of %T", key, v)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// stringParam returns string parameter or default value if parameter is missing.
+func stringParam(p map[string]any, key string, defaultValue string) (string, error) {
+	v, ok := p[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected %s as string instead of %T", key, v)
+	}
+	return s, nil
+}
+
+// checkKeys returns error if p contains any key which is not allowed.
+func checkKeys(p map[string]any, allowed []string) error {
+	unknown := []string(nil)
+	for k := range p {
+		found := false
+		for _, a := range allowed {
+			if k == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown parameters %q, expected some of %q", unknown, allowed)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.lstv.dev/goproxy/source"
+	"go.lstv.dev/goproxy/util"
+)
+
+const (
+	// DefaultAssetName is default template of release asset names.
+	DefaultAssetName = "{name}-{version}-{arch}"
+	// DefaultDownloadsTagPrefix is default prefix of tags of release assets and job artifacts.
+	DefaultDownloadsTagPrefix = "v"
+)
+
+// releaseAssets are downloads of links of GitLab releases, versions are tags of releases.
+type releaseAssets struct {
+	*Source
+	name      string
+	projectID int64
+	tagPrefix string
+	assetName assetTemplate
+}
+
+type releaseLink struct {
+	Name           string `json:"name"`
+	URL            string `json:"url"`
+	DirectAssetURL string `json:"direct_asset_url"`
+}
+
+type release struct {
+	TagName    string    `json:"tag_name"`
+	ReleasedAt time.Time `json:"released_at"`
+	Assets     struct {
+		Links []releaseLink `json:"links"`
+	} `json:"assets"`
+}
+
+func (r *releaseAssets) ConfigPreview() (pairs []string) {
+	return []string{
+		"type", "gitlab",
+		"mode", "release-assets",
+		"url", r.url,
+		"project_id", strconv.FormatInt(r.projectID, 10),
+		"tag_prefix", r.tagPrefix,
+		"asset_name", string(r.assetName),
+		"insecure_tls", strconv.FormatBool(r.insecureTLS),
+		"forward_credentials", strconv.FormatBool(r.forwardCredentials),
+	}
+}
+
+// CheckAccess verifies access of forwarded client credentials to the project.
+func (r *releaseAssets) CheckAccess(ctx context.Context) error {
+	return r.checkProjectAccess(ctx, r.projectID)
+}
+
+// Check verifies that the project is accessible with the source token and contains any release.
+func (r *releaseAssets) Check(ctx context.Context) error {
+	if err := r.checkProject(ctx, r.projectID); err != nil {
+		return err
+	}
+	if _, err := r.LatestDownloadVersion(ctx); err != nil {
+		return fmt.Errorf("Check: %w", err)
+	}
+	return nil
+}
+
+// version returns version of release tag.
+func (r *releaseAssets) version(tag string) (util.Version, bool) {
+	if !strings.HasPrefix(tag, r.tagPrefix) {
+		return util.Version{}, false
+	}
+	v, err := util.ParseVersion(tag[len(r.tagPrefix):])
+	return v, err == nil
+}
+
+// releases returns all releases of the project.
+func (r *releaseAssets) releases(ctx context.Context) ([]release, error) {
+	releases := []release(nil)
+	for page := 1; ; page++ {
+		url := r.apiURL(fmt.Sprintf("projects/%d/releases?page=%d&per_page=100", r.projectID, page))
+		result := []release(nil)
+		hasNextPage, err := r.getPage(ctx, url, page, &result)
+		if err != nil {
+			return nil, fmt.Errorf("releases: %w", err)
+		}
+		releases = append(releases, result...)
+		if !hasNextPage {
+			return releases, nil
+		}
+	}
+}
+
+// release returns release of version.
+func (r *releaseAssets) release(ctx context.Context, v util.Version) (release, error) {
+	tag := r.tagPrefix + v.String()
+	url := r.apiURL(fmt.Sprintf("projects/%d/releases/%s", r.projectID, neturl.PathEscape(tag)))
+	result := release{}
+	resp, err := r.doGetRequest(ctx, url)
+	if err != nil {
+		return result, fmt.Errorf("release: %w", err)
+	}
+	defer r.log.Ctx(ctx).NoErrClose(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return result, source.NewVersionNotFoundError(fmt.Errorf("no release of tag %q", tag))
+	default:
+		return result, fmt.Errorf("release: unexpected status code %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return result, fmt.Errorf("release: %w", err)
+	}
+	return result, nil
+}
+
+// architectures returns sorted architectures of release links.
+func (r *releaseAssets) architectures(rel release, v util.Version) []string {
+	archs := []string(nil)
+	seen := map[string]bool{}
+	for _, l := range rel.Assets.Links {
+		arch, ok := r.assetName.architecture(r.name, v, l.Name)
+		if ok && !seen[arch] {
+			seen[arch] = true
+			archs = append(archs, arch)
+		}
+	}
+	sort.Strings(archs)
+	return archs
+}
+
+func (r *releaseAssets) LatestDownloadVersion(ctx context.Context) (latest util.Version, err error) {
+	versions, err := r.ListDownloadVersions(ctx)
+	if err != nil {
+		return util.Version{}, err
+	}
+	if len(versions) == 0 {
+		return util.Version{}, source.NewVersionNotFoundError(fmt.Errorf("no release with tag prefix %q", r.tagPrefix))
+	}
+	return versions[0].Version, nil
+}
+
+// ListDownloadVersions returns versions of releases with tag prefix.
+func (r *releaseAssets) ListDownloadVersions(ctx context.Context) ([]source.DownloadVersion, error) {
+	releases, err := r.releases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := []source.DownloadVersion(nil)
+	for _, rel := range releases {
+		v, ok := r.version(rel.TagName)
+		if !ok {
+			continue
+		}
+		versions = append(versions, source.DownloadVersion{
+			Version:       v,
+			Architectures: r.architectures(rel, v),
+			Published:     rel.ReleasedAt,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version.Compare(versions[j].Version) > 0
+	})
+	return versions, nil
+}
+
+// DownloadArchitectures returns architectures of links of release.
+func (r *releaseAssets) DownloadArchitectures(ctx context.Context, v util.Version) ([]string, error) {
+	rel, err := r.release(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	return r.architectures(rel, v), nil
+}
+
+// linkURL returns URL of release link with name.
+func (r *releaseAssets) linkURL(ctx context.Context, v util.Version, name string) (string, error) {
+	rel, err := r.release(ctx, v)
+	if err != nil {
+		return "", err
+	}
+	for _, l := range rel.Assets.Links {
+		if l.Name != name {
+			continue
+		}
+		if l.DirectAssetURL != "" {
+			return l.DirectAssetURL, nil
+		}
+		return l.URL, nil
+	}
+	return "", source.NewVersionNotFoundError(fmt.Errorf("no asset %q in release %s", name, v))
+}
+
+// DownloadURL returns URL of release link, clients must be authorized to download it.
+func (r *releaseAssets) DownloadURL(ctx context.Context, v util.Version, arch string) (string, error) {
+	return r.linkURL(ctx, v, r.assetName.render(r.name, v, arch))
+}
+
+func (r *releaseAssets) WriteDownload(ctx context.Context, w http.ResponseWriter, req *http.Request, v util.Version, arch string) {
+	r.writeLink(ctx, w, req, v, r.assetName.render(r.name, v, arch))
+}
+
+// WriteDownloadFile writes release link with name of asset and suffix, e.g. tool-1.0.0-linux-amd64.sig.
+func (r *releaseAssets) WriteDownloadFile(ctx context.Context, w http.ResponseWriter, req *http.Request, v util.Version, arch, suffix string) {
+	r.writeLink(ctx, w, req, v, r.assetName.render(r.name, v, arch)+suffix)
+}
+
+func (r *releaseAssets) writeLink(ctx context.Context, w http.ResponseWriter, req *http.Request, v util.Version, name string) {
+	url, err := r.linkURL(ctx, v, name)
+	if err != nil {
+		r.log.Ctx(ctx).Err(err).With(
+			"asset", name,
+		).Debug("release asset not found")
+		if source.IsVersionNotFound(err) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.writeFile(ctx, w, req, url)
+}
+
+// DownloadLayout returns layout derived from asset name template.
+func (r *releaseAssets) DownloadLayout() (disableArchitecture bool, fileExtension string) {
+	return r.assetName.disableArchitecture(), r.assetName.fileExtension()
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"go.lstv.dev/goproxy/util"
 )
@@ -17,6 +18,8 @@ import (
 var (
 	ErrNotParametrized = errors.New("source is not parametrized")
 	ErrNotRegistered   = errors.New("source type is not registered")
+	ErrUnauthenticated = errors.New("invalid credentials")
+	ErrAccessDenied    = errors.New("access denied")
 
 	sourcesMutex sync.Mutex
 	sources      = map[string]func(map[string]any) (Source, error){}
@@ -58,6 +61,7 @@ func Register(name string, builder func(map[string]any) (Source, error)) bool {
 	return true
 }
 
+// New returns source of registered type name, params contain also configured "name" of source.
 func New(name string, params map[string]any) (Source, error) {
 	if b := builder(name); b != nil {
 		return b(params)
@@ -110,9 +114,111 @@ type Downloads interface {
 	// ConfigPreview returns key-value pairs of configuration preview.
 	ConfigPreview() (pairs []string)
 
-	WriteDownload(ctx context.Context, w http.ResponseWriter, v util.Version, arch string)
+	// WriteDownload writes the download of version and architecture as response to GET or HEAD request.
+	// Range requests are supported if the source supports them.
+	// Failure after the response was started (e.g. interrupted upstream body) is reported by AbortDownload.
+	WriteDownload(ctx context.Context, w http.ResponseWriter, req *http.Request, v util.Version, arch string)
 
 	LatestDownloadVersion(ctx context.Context) (latest util.Version, err error)
+
+	// ListDownloadVersions returns all published versions sorted from the latest.
+	// Architectures may be unknown (nil) if listing them is expensive, see DownloadFiles.
+	ListDownloadVersions(ctx context.Context) ([]DownloadVersion, error)
+}
+
+// DownloadVersion is published version of download.
+type DownloadVersion struct {
+	Version       util.Version `json:"version"`
+	Architectures []string     `json:"architectures"` // sorted, the only empty architecture if disabled
+	Published     time.Time    `json:"published"`
+	ID            int64        `json:"-"` // identifier of version at the source (e.g. package ID), see ListedArchitectures
+}
+
+// TagMatcher is optionally implemented by parametrized sources
+// which are able to map tags pushed to a project to module versions.
+type TagMatcher interface {
+	// MatchTag returns module version for tag pushed to project with specified ID.
+	MatchTag(projectID int64, tag string) (version string, ok bool)
+}
+
+// CacheInvalidator is optionally implemented by sources caching upstream responses.
+type CacheInvalidator interface {
+	// InvalidateCache drops all cached responses.
+	InvalidateCache()
+}
+
+// Authenticator is optionally implemented by sources which are able to verify user tokens.
+type Authenticator interface {
+	// Authenticate returns user owning the token.
+	// ErrUnauthenticated is returned for invalid token.
+	Authenticate(ctx context.Context, token string) (TokenOwner, error)
+}
+
+// TokenOwner is user owning token verified by Authenticator.
+type TokenOwner struct {
+	User   string
+	Scopes []string // sorted scopes of the token, nil if the token has no scopes (e.g. job token)
+}
+
+// AccessChecker is optionally implemented by sources and downloads which forward client credentials.
+type AccessChecker interface {
+	// CheckAccess verifies that client credentials from context grant access to the source.
+	// ErrUnauthenticated is returned for missing or invalid credentials, ErrAccessDenied for insufficient access.
+	CheckAccess(ctx context.Context) error
+}
+
+// Checker is optionally implemented by sources and downloads which are able to verify configuration upstream.
+type Checker interface {
+	// Check verifies that upstream is reachable and configuration matches its content
+	// (e.g. token is valid, project exists).
+	Check(ctx context.Context) error
+}
+
+// DownloadLocator is optionally implemented by downloads which are able to provide URL of download,
+// clients are redirected to it instead of streaming.
+type DownloadLocator interface {
+	// DownloadURL returns upstream URL of download of version and architecture.
+	DownloadURL(ctx context.Context, v util.Version, arch string) (string, error)
+}
+
+// ListedArchitectures is optionally implemented by DownloadFiles which are able to list architectures
+// of version returned by ListDownloadVersions by its ID, without looking up the version again.
+type ListedArchitectures interface {
+	// ListedArchitectures returns sorted architectures of listed version.
+	ListedArchitectures(ctx context.Context, dv DownloadVersion) ([]string, error)
+}
+
+// DownloadFiles is optionally implemented by downloads which are able to list architectures of a version
+// and to provide companion files of downloads (e.g. detached signatures).
+type DownloadFiles interface {
+	// DownloadArchitectures returns sorted architectures available at version.
+	// Download without architecture has the only empty architecture.
+	DownloadArchitectures(ctx context.Context, v util.Version) ([]string, error)
+
+	// WriteDownloadFile writes companion file of the download with suffix (e.g. ".sig")
+	// as response to GET or HEAD request.
+	WriteDownloadFile(ctx context.Context, w http.ResponseWriter, req *http.Request, v util.Version, arch, suffix string)
+}
+
+// DownloadLayout is optionally implemented by downloads to describe their files, e.g. for install scripts.
+type DownloadLayout interface {
+	// DownloadLayout returns whether downloads are without architecture and extension of download files
+	// (e.g. ".tar.gz", empty for plain binaries).
+	DownloadLayout() (disableArchitecture bool, fileExtension string)
+}
+
+// DownloadAborter is optionally implemented by response writers passed to Downloads.WriteDownload
+// which must not accept the download if it fails after the response was started, e.g. writers storing downloads.
+type DownloadAborter interface {
+	// AbortDownload marks the written download as failed.
+	AbortDownload(err error)
+}
+
+// AbortDownload reports failed download to w if it implements DownloadAborter.
+func AbortDownload(w http.ResponseWriter, err error) {
+	if a, ok := w.(DownloadAborter); ok {
+		a.AbortDownload(err)
+	}
 }
 
 func builder(name string) func(map[string]any) (Source, error) {
@@ -6,7 +6,6 @@ package storage
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -63,6 +62,10 @@ func (d *Dir) listModules(dir string) ([]string, error) {
 	containsModules := false
 	for _, dirEntry := range dirEntries {
 		if dirEntry.IsDir() {
+			if dir == "" && strings.HasPrefix(dirEntry.Name(), "@") {
+				// stored downloads and temporary directories are not modules
+				continue
+			}
 			modules, err := d.listModules(path.Join(dir, dirEntry.Name()))
 			if err != nil {
 				return nil, err
@@ -111,7 +114,7 @@ func (d *Dir) ListVersions(module string, major *uint) ([]string, error) {
 	return result, nil
 }
 
-func (d *Dir) Open(module, version, suffix string) (io.ReadCloser, error) {
+func (d *Dir) Open(module, version, suffix string) (*os.File, error) {
 	if ok, err := d.IsLocked(module, version); ok {
 		return nil, ErrCurrentlyLocked
 	} else if err != nil {
@@ -182,6 +185,86 @@ func (d *Dir) StoredModules() ([]StoredModuleInfo, error) {
 	return info, nil
 }
 
+// DeleteVersion removes stored files of module at specified version.
+// Error os.ErrNotExist is returned if version is not stored.
+func (d *Dir) DeleteVersion(module, version string) error {
+	if err := validModuleVersion(module, version); err != nil {
+		return err
+	}
+	if ok, err := d.IsLocked(module, version); ok {
+		return ErrCurrentlyLocked
+	} else if err != nil {
+		return err
+	}
+	dir := d.ModuleDir(module)
+	// info file is removed first, the version is not listed anymore
+	if err := os.Remove(filepath.Join(dir, version+".info")); err != nil {
+		return err
+	}
+	for _, suffix := range []string{"mod", "zip"} {
+		if err := os.Remove(filepath.Join(dir, version+"."+suffix)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// TempDir creates new temporary directory in storage, e.g. Chroot of module version being replaced.
+// The caller removes the directory.
+func (d *Dir) TempDir() (string, error) {
+	root := filepath.Join(d.Chroot, tmpDir)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(root, "")
+}
+
+// ReplaceVersion moves files of module version stored at directory from (e.g. TempDir) to storage,
+// replacing stored files of the version. Info file is moved last, the version is listed only if complete.
+func (d *Dir) ReplaceVersion(from, module, version string) error {
+	if err := validModuleVersion(module, version); err != nil {
+		return err
+	}
+	if ok, err := d.IsLocked(module, version); ok {
+		return ErrCurrentlyLocked
+	} else if err != nil {
+		return err
+	}
+	src := Dir{Chroot: from}
+	srcDir := src.ModuleDir(module)
+	if _, err := os.Stat(filepath.Join(srcDir, version+".info")); err != nil {
+		return err
+	}
+	dir := d.ModuleDir(module)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, suffix := range []string{"mod", "zip", "info"} {
+		if err := os.Rename(filepath.Join(srcDir, version+"."+suffix), filepath.Join(dir, version+"."+suffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteModule removes stored files of all versions of module regardless of version suffix.
+// Directories of nested modules are kept.
+func (d *Dir) DeleteModule(module string) error {
+	if err := validModuleVersion(module, ""); err != nil {
+		return err
+	}
+	versions, err := d.ListVersions(module, nil)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if err := d.DeleteVersion(module, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *Dir) IsLocked(module, version string) (bool, error) {
 	dir := d.ModuleDir(module)
 	return checkFile(filepath.Join(dir, version+".lock"))
@@ -201,6 +284,18 @@ func (d *Dir) moduleVersionInfo(module, version string) (size int64, downloaded
 	}
 }
 
+func validModuleVersion(module, version string) error {
+	if module == "" || path.Clean(module) != module || path.IsAbs(module) || strings.HasPrefix(module, "..") {
+		return fmt.Errorf("%w %q", ErrInvalidModule, module)
+	}
+	if version != "" {
+		if _, err := util.ParseTagVersion(version); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidModule, err)
+		}
+	}
+	return nil
+}
+
 func checkFile(file string) (bool, error) {
 	if _, err := os.Stat(file); err != nil {
 		if os.IsNotExist(err) {
@@ -0,0 +1,92 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDir(t *testing.T, files ...string) *Dir {
+	d := &Dir{
+		Chroot: t.TempDir(),
+	}
+	for _, f := range files {
+		file := filepath.Join(d.Chroot, f)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(f), 0644))
+	}
+	return d
+}
+
+func Test_Dir_DeleteVersion(t *testing.T) {
+	d := newTestDir(t,
+		"example.com/a/v1.0.0.info",
+		"example.com/a/v1.0.0.mod",
+		"example.com/a/v1.0.0.zip",
+		"example.com/a/v1.1.0.info",
+		"example.com/a/v1.1.0.lock",
+	)
+	assert.NoError(t, d.DeleteVersion("example.com/a", "v1.0.0"))
+	ok, err := d.HasVersion("example.com/a", "v1.0.0")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoFileExists(t, filepath.Join(d.Chroot, "example.com/a/v1.0.0.zip"))
+
+	assert.ErrorIs(t, d.DeleteVersion("example.com/a", "v1.0.0"), os.ErrNotExist)
+	assert.ErrorIs(t, d.DeleteVersion("example.com/a", "v1.1.0"), ErrCurrentlyLocked)
+	assert.ErrorIs(t, d.DeleteVersion("example.com/a", "1.1.0"), ErrInvalidModule)
+	assert.ErrorIs(t, d.DeleteVersion("../a", "v1.1.0"), ErrInvalidModule)
+	assert.ErrorIs(t, d.DeleteVersion("/a", "v1.1.0"), ErrInvalidModule)
+}
+
+func Test_Dir_DeleteModule(t *testing.T) {
+	d := newTestDir(t,
+		"example.com/a/v1.0.0.info",
+		"example.com/a/v1.0.0.mod",
+		"example.com/a/v1.0.0.zip",
+		"example.com/a/v2.0.0.info",
+		"example.com/a/v2.0.0.mod",
+		"example.com/a/v2.0.0.zip",
+		"example.com/a/b/v1.0.0.info",
+	)
+	assert.NoError(t, d.DeleteModule("example.com/a"))
+	modules, err := d.ListModules()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com/a/b"}, modules)
+	assert.ErrorIs(t, d.DeleteModule(""), ErrInvalidModule)
+}
+
+func Test_Dir_ReplaceVersion(t *testing.T) {
+	d := newTestDir(t,
+		"example.com/a/v1.0.0.info",
+		"example.com/a/v1.0.0.mod",
+		"example.com/a/v1.0.0.zip",
+		"example.com/a/v1.1.0.lock",
+	)
+	tmp, err := d.TempDir()
+	require.NoError(t, err)
+	assert.ErrorIs(t, d.ReplaceVersion(tmp, "example.com/a", "v1.0.0"), os.ErrNotExist)
+	for _, f := range []string{"v1.0.0.info", "v1.0.0.mod", "v1.0.0.zip"} {
+		file := filepath.Join(tmp, "example.com/a", f)
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte("new"), 0644))
+	}
+	assert.NoError(t, d.ReplaceVersion(tmp, "example.com/a", "v1.0.0"))
+	b, err := os.ReadFile(filepath.Join(d.Chroot, "example.com/a/v1.0.0.zip"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(b))
+
+	assert.ErrorIs(t, d.ReplaceVersion(tmp, "example.com/a", "v1.1.0"), ErrCurrentlyLocked)
+	assert.ErrorIs(t, d.ReplaceVersion(tmp, "../a", "v1.0.0"), ErrInvalidModule)
+
+	modules, err := d.ListModules()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com/a"}, modules)
+}
@@ -0,0 +1,246 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// downloadsDir contains stored downloads, the name is not a valid module path.
+	downloadsDir = "@downloads"
+	// tmpDir contains temporary directories, the name is not a valid module path.
+	tmpDir = "@tmp"
+	// noArchitecture is file name of download without architecture.
+	noArchitecture = "_"
+
+	downloadFileSuffix = ".file"
+	downloadMetaSuffix = ".meta"
+	downloadLockSuffix = ".lock"
+	downloadTmpSuffix  = ".tmp"
+)
+
+// DownloadMeta contains response headers of stored download.
+type DownloadMeta struct {
+	ContentType        string `json:"content_type,omitempty"`
+	ContentDisposition string `json:"content_disposition,omitempty"`
+}
+
+type StoredDownloadInfo struct {
+	Name       string
+	Version    string
+	Arch       string
+	Downloaded time.Time
+	Size       int64
+	Locked     bool
+}
+
+func (d *Dir) downloadDir(name, version string) string {
+	return filepath.Join(d.Chroot, downloadsDir, name, version)
+}
+
+func (d *Dir) downloadPath(name, version, arch string) string {
+	if arch == "" {
+		arch = noArchitecture
+	}
+	return filepath.Join(d.downloadDir(name, version), arch)
+}
+
+// OpenDownload opens stored download of version and architecture (empty if disabled).
+// Error os.ErrNotExist is returned if download is not stored.
+func (d *Dir) OpenDownload(name, version, arch string) (*os.File, DownloadMeta, error) {
+	meta := DownloadMeta{}
+	if err := validDownload(name, version, arch); err != nil {
+		return nil, meta, err
+	}
+	path := d.downloadPath(name, version, arch)
+	if ok, err := checkFile(path + downloadLockSuffix); ok {
+		return nil, meta, ErrCurrentlyLocked
+	} else if err != nil {
+		return nil, meta, err
+	}
+	f, err := os.Open(path + downloadFileSuffix)
+	if err != nil {
+		return nil, meta, err
+	}
+	if b, err := os.ReadFile(path + downloadMetaSuffix); err == nil {
+		if err := json.Unmarshal(b, &meta); err != nil {
+			_ = f.Close()
+			return nil, meta, fmt.Errorf("invalid download meta: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		_ = f.Close()
+		return nil, meta, err
+	}
+	return f, meta, nil
+}
+
+// StoreDownload stores download written by write. Download is locked until it is stored,
+// ErrCurrentlyLocked is returned if the download is being stored by another request.
+func (d *Dir) StoreDownload(name, version, arch string, write func(w io.Writer) (DownloadMeta, error)) error {
+	if err := validDownload(name, version, arch); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.downloadDir(name, version), 0755); err != nil {
+		return err
+	}
+	path := d.downloadPath(name, version, arch)
+	lock, err := os.OpenFile(path+downloadLockSuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return ErrCurrentlyLocked
+		}
+		return err
+	}
+	_, _ = lock.WriteString(time.Now().String())
+	_ = lock.Close()
+	defer func() {
+		_ = os.Remove(path + downloadLockSuffix)
+	}()
+
+	tmp, err := os.Create(path + downloadTmpSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	meta, err := write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	// meta is written first, the stored file is always complete
+	if err := os.WriteFile(path+downloadMetaSuffix, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path+downloadFileSuffix)
+}
+
+// StoredDownloads returns stored downloads sorted by name, version and architecture.
+func (d *Dir) StoredDownloads() ([]StoredDownloadInfo, error) {
+	root := filepath.Join(d.Chroot, downloadsDir)
+	names, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	info := []StoredDownloadInfo(nil)
+	for _, n := range names {
+		if !n.IsDir() {
+			continue
+		}
+		versions, err := os.ReadDir(filepath.Join(root, n.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			if !v.IsDir() {
+				continue
+			}
+			files, err := os.ReadDir(d.downloadDir(n.Name(), v.Name()))
+			if err != nil {
+				return nil, err
+			}
+			archs := map[string]*StoredDownloadInfo{}
+			for _, f := range files {
+				file := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+				i, ok := archs[file]
+				if !ok {
+					i = &StoredDownloadInfo{
+						Name:    n.Name(),
+						Version: v.Name(),
+						Arch:    file,
+					}
+					if file == noArchitecture {
+						i.Arch = ""
+					}
+				}
+				switch filepath.Ext(f.Name()) {
+				case downloadFileSuffix:
+					s, err := f.Info()
+					if err != nil {
+						return nil, err
+					}
+					i.Size = s.Size()
+					i.Downloaded = s.ModTime()
+				case downloadLockSuffix:
+					i.Locked = true
+				default:
+					continue
+				}
+				archs[file] = i
+			}
+			for _, i := range archs {
+				info = append(info, *i)
+			}
+		}
+	}
+	sort.Slice(info, func(i, j int) bool {
+		a, b := info[i], info[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Arch < b.Arch
+	})
+	return info, nil
+}
+
+// DeleteDownload removes stored download of version or of all versions if version is empty.
+// Error os.ErrNotExist is returned if download is not stored.
+func (d *Dir) DeleteDownload(name, version string) error {
+	if err := validDownload(name, version, ""); err != nil {
+		return err
+	}
+	dir := filepath.Join(d.Chroot, downloadsDir, name, version)
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	locked := false
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, downloadLockSuffix) {
+			locked = true
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if locked {
+		return ErrCurrentlyLocked
+	}
+	return os.RemoveAll(dir)
+}
+
+// validDownload checks that path elements of download are safe, empty version and architecture are allowed.
+func validDownload(name, version, arch string) error {
+	for _, e := range []string{name, version, arch} {
+		if strings.ContainsAny(e, `/\`) || strings.HasPrefix(e, ".") {
+			return fmt.Errorf("%w %q", ErrInvalidDownload, e)
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidDownload)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Dir_StoreDownload(t *testing.T) {
+	d := newTestDir(t)
+	_, _, err := d.OpenDownload("tool", "1.0.0", "linux-amd64")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	meta := DownloadMeta{ContentType: "application/gzip"}
+	require.NoError(t, d.StoreDownload("tool", "1.0.0", "linux-amd64", func(w io.Writer) (DownloadMeta, error) {
+		// concurrent store of the same download is rejected
+		assert.ErrorIs(t, d.StoreDownload("tool", "1.0.0", "linux-amd64", nil), ErrCurrentlyLocked)
+		_, _, err := d.OpenDownload("tool", "1.0.0", "linux-amd64")
+		assert.ErrorIs(t, err, ErrCurrentlyLocked)
+		_, err = w.Write([]byte("binary"))
+		return meta, err
+	}))
+	require.NoError(t, d.StoreDownload("tool", "1.0.0", "", func(w io.Writer) (DownloadMeta, error) {
+		_, err := w.Write([]byte("no arch"))
+		return DownloadMeta{}, err
+	}))
+	// failed store is not kept
+	assert.EqualError(t, d.StoreDownload("tool", "2.0.0", "linux-amd64", func(w io.Writer) (DownloadMeta, error) {
+		_, _ = w.Write([]byte("partial"))
+		return DownloadMeta{}, errors.New("failed")
+	}), "failed")
+
+	f, m, err := d.OpenDownload("tool", "1.0.0", "linux-amd64")
+	require.NoError(t, err)
+	b, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.Equal(t, "binary", string(b))
+	assert.Equal(t, meta, m)
+	_, _, err = d.OpenDownload("tool", "2.0.0", "linux-amd64")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, _, err = d.OpenDownload("tool", "1.0.0", "..")
+	assert.ErrorIs(t, err, ErrInvalidDownload)
+
+	stored, err := d.StoredDownloads()
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "", stored[0].Arch)
+	assert.Equal(t, int64(7), stored[0].Size)
+	assert.Equal(t, "linux-amd64", stored[1].Arch)
+	assert.Equal(t, int64(6), stored[1].Size)
+	assert.False(t, stored[1].Locked)
+
+	// modules are not affected by downloads
+	modules, err := d.ListModules()
+	assert.NoError(t, err)
+	assert.Empty(t, modules)
+}
+
+func Test_Dir_DeleteDownload(t *testing.T) {
+	d := newTestDir(t,
+		"@downloads/tool/1.0.0/linux-amd64.file",
+		"@downloads/tool/1.1.0/linux-amd64.file",
+		"@downloads/tool/1.2.0/linux-amd64.lock",
+		"@downloads/other/1.0.0/_.file",
+	)
+	assert.NoError(t, d.DeleteDownload("tool", "1.0.0"))
+	assert.ErrorIs(t, d.DeleteDownload("tool", "1.0.0"), os.ErrNotExist)
+	assert.ErrorIs(t, d.DeleteDownload("tool", "1.2.0"), ErrCurrentlyLocked)
+	assert.ErrorIs(t, d.DeleteDownload("tool", ""), ErrCurrentlyLocked)
+	assert.ErrorIs(t, d.DeleteDownload("../tool", ""), ErrInvalidDownload)
+	assert.ErrorIs(t, d.DeleteDownload("", ""), ErrInvalidDownload)
+	assert.NoError(t, d.DeleteDownload("other", ""))
+
+	stored, err := d.StoredDownloads()
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "1.1.0", stored[0].Version)
+	assert.True(t, stored[1].Locked)
+}
@@ -11,12 +11,24 @@ import (
 	"go.lstv.dev/goproxy/util"
 )
 
-var ErrCurrentlyLocked = errors.New("currently locked")
+var (
+	ErrCurrentlyLocked = errors.New("currently locked")
+	ErrInvalidModule   = errors.New("invalid module")
+	ErrInvalidDownload = errors.New("invalid download")
+)
 
 func IsCurrentlyLocked(err error) bool {
 	return errors.Is(err, ErrCurrentlyLocked)
 }
 
+func IsInvalidModule(err error) bool {
+	return errors.Is(err, ErrInvalidModule)
+}
+
+func IsInvalidDownload(err error) bool {
+	return errors.Is(err, ErrInvalidDownload)
+}
+
 type StoredModuleInfo struct {
 	Name      string
 	Versions  []StoredModuleVersionInfo
@@ -0,0 +1,184 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidConstraint = errors.New("invalid version constraint")
+
+type comparator struct {
+	op string // one of =, >, >=, <, <=
+	v  Version
+}
+
+func (c comparator) match(v Version) bool {
+	r := v.Compare(c.v)
+	switch c.op {
+	case ">":
+		return r > 0
+	case ">=":
+		return r >= 0
+	case "<":
+		return r < 0
+	case "<=":
+		return r <= 0
+	}
+	return r == 0
+}
+
+// Constraint is a range of versions, e.g. "^1.4", "~2.1", ">=1.2 <2" or "1.x || 2.1".
+// Comparators separated by spaces or commas must all match, alternatives are separated by "||".
+//
+// Pre-release versions match only if a comparator of the alternative has pre-release
+// at the same major, minor and patch version.
+type Constraint struct {
+	sets [][]comparator
+}
+
+// ParseConstraint parses constraint of caret (^), tilde (~), comparison (=, >, >=, <, <=)
+// or partial versions (1, 1.4, 1.4.x).
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{}
+	for _, alternative := range strings.Split(s, "||") {
+		tokens := strings.FieldsFunc(alternative, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+		set := []comparator(nil)
+		for i := 0; i < len(tokens); i++ {
+			token := tokens[i]
+			// operator may be separated from version by space
+			if strings.TrimLeft(token, "^~=<>") == "" && i+1 < len(tokens) {
+				i++
+				token += tokens[i]
+			}
+			comparators, err := parseComparators(token)
+			if err != nil {
+				return Constraint{}, fmt.Errorf("%w %q: %s", ErrInvalidConstraint, s, err)
+			}
+			set = append(set, comparators...)
+		}
+		if len(set) == 0 {
+			return Constraint{}, fmt.Errorf("%w %q: empty constraint", ErrInvalidConstraint, s)
+		}
+		c.sets = append(c.sets, set)
+	}
+	return c, nil
+}
+
+// parseComparators expands comparator with partial version to range of comparators.
+func parseComparators(token string) ([]comparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", "^", "~", "=", ">", "<"} {
+		if strings.HasPrefix(token, prefix) {
+			op = prefix
+			break
+		}
+	}
+	v, parts, err := parsePartialVersion(token[len(op):])
+	if err != nil {
+		return nil, err
+	}
+	nextMajor := Version{Major: v.Major + 1}
+	nextMinor := Version{Major: v.Major, Minor: v.Minor + 1}
+	next := nextMajor // upper bound of partial version
+	if parts == 2 {
+		next = nextMinor
+	}
+	switch op {
+	case "^":
+		upper := Version{Major: 0, Minor: 0, Patch: v.Patch + 1}
+		switch {
+		case v.Major > 0 || parts == 1:
+			upper = nextMajor
+		case v.Minor > 0 || parts == 2:
+			upper = nextMinor
+		}
+		return []comparator{{op: ">=", v: v}, {op: "<", v: upper}}, nil
+	case "~":
+		upper := nextMinor
+		if parts == 1 {
+			upper = nextMajor
+		}
+		return []comparator{{op: ">=", v: v}, {op: "<", v: upper}}, nil
+	case "", "=":
+		if parts == 3 {
+			return []comparator{{op: "=", v: v}}, nil
+		}
+		return []comparator{{op: ">=", v: v}, {op: "<", v: next}}, nil
+	case ">":
+		if parts == 3 {
+			return []comparator{{op: ">", v: v}}, nil
+		}
+		return []comparator{{op: ">=", v: next}}, nil
+	case "<=":
+		if parts == 3 {
+			return []comparator{{op: "<=", v: v}}, nil
+		}
+		return []comparator{{op: "<", v: next}}, nil
+	}
+	return []comparator{{op: op, v: v}}, nil
+}
+
+// parsePartialVersion parses version with optional minor and patch (missing or x, X, *)
+// and returns number of specified parts.
+func parsePartialVersion(s string) (Version, int, error) {
+	if v, err := ParseVersion(s); err == nil {
+		return v, 3, nil
+	}
+	v := Version{}
+	elements := strings.Split(s, ".")
+	if len(elements) > 3 {
+		return Version{}, 0, InvalidVersionFormatError(s)
+	}
+	numbers := []*uint{&v.Major, &v.Minor, &v.Patch}
+	parts := 0
+	for i, e := range elements {
+		if e == "x" || e == "X" || e == "*" {
+			if i == 0 {
+				return Version{}, 0, InvalidVersionFormatError(s)
+			}
+			break
+		}
+		n, err := strconv.ParseUint(e, 10, 32)
+		if err != nil || (len(e) > 1 && e[0] == '0') {
+			return Version{}, 0, InvalidVersionFormatError(s)
+		}
+		*numbers[i] = uint(n)
+		parts++
+	}
+	if parts == 3 {
+		// full version is parsed by ParseVersion
+		return Version{}, 0, InvalidVersionFormatError(s)
+	}
+	return v, parts, nil
+}
+
+// Match reports whether version satisfies the constraint.
+func (c Constraint) Match(v Version) bool {
+	for _, set := range c.sets {
+		if matchSet(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSet(set []comparator, v Version) bool {
+	preRelease := v.PreRelease == ""
+	for _, c := range set {
+		if !c.match(v) {
+			return false
+		}
+		if c.v.PreRelease != "" && c.v.Major == v.Major && c.v.Minor == v.Minor && c.v.Patch == v.Patch {
+			preRelease = true
+		}
+	}
+	return preRelease
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 Livesport TV s.r.o. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Constraint_Match(t *testing.T) {
+	cases := []struct {
+		Constraint string
+		Match      []string
+		NoMatch    []string
+	}{
+		{Constraint: "^1.4", Match: []string{"1.4.0", "1.9.3"}, NoMatch: []string{"1.3.9", "2.0.0", "1.5.0-rc.1"}},
+		{Constraint: "^0.4", Match: []string{"0.4.0", "0.4.7"}, NoMatch: []string{"0.5.0", "0.3.0"}},
+		{Constraint: "^0.0.3", Match: []string{"0.0.3"}, NoMatch: []string{"0.0.4"}},
+		{Constraint: "^1", Match: []string{"1.0.0", "1.9.0"}, NoMatch: []string{"2.0.0", "0.9.0"}},
+		{Constraint: "~2.1", Match: []string{"2.1.0", "2.1.9"}, NoMatch: []string{"2.2.0", "2.0.9"}},
+		{Constraint: "~2.1.3", Match: []string{"2.1.3", "2.1.4"}, NoMatch: []string{"2.1.2", "2.2.0"}},
+		{Constraint: "~2", Match: []string{"2.0.0", "2.9.0"}, NoMatch: []string{"3.0.0"}},
+		{Constraint: ">=1.2 <2", Match: []string{"1.2.0", "1.9.9"}, NoMatch: []string{"1.1.9", "2.0.0"}},
+		{Constraint: ">= 1.2, < 2", Match: []string{"1.2.0"}, NoMatch: []string{"2.0.0"}},
+		{Constraint: ">1.2", Match: []string{"1.3.0"}, NoMatch: []string{"1.2.9"}},
+		{Constraint: ">1.2.0", Match: []string{"1.2.1"}, NoMatch: []string{"1.2.0"}},
+		{Constraint: "<=1.2", Match: []string{"1.2.9"}, NoMatch: []string{"1.3.0"}},
+		{Constraint: "<1.2.0", Match: []string{"1.1.9"}, NoMatch: []string{"1.2.0"}},
+		{Constraint: "1.x", Match: []string{"1.0.0", "1.9.0"}, NoMatch: []string{"2.0.0"}},
+		{Constraint: "1.4", Match: []string{"1.4.2"}, NoMatch: []string{"1.5.0"}},
+		{Constraint: "=1.4.2", Match: []string{"1.4.2"}, NoMatch: []string{"1.4.3"}},
+		{Constraint: "1.x || ^3.1", Match: []string{"1.2.0", "3.2.0"}, NoMatch: []string{"2.0.0", "3.0.0"}},
+		{Constraint: ">=1.5.0-rc.1 <2", Match: []string{"1.5.0-rc.1", "1.5.0-rc.2", "1.6.0"}, NoMatch: []string{"1.6.0-rc.1", "1.5.0-alpha"}},
+	}
+	for _, c := range cases {
+		constraint, err := ParseConstraint(c.Constraint)
+		require.NoErrorf(t, err, "constraint %q", c.Constraint)
+		for _, s := range c.Match {
+			v, err := ParseVersion(s)
+			require.NoError(t, err)
+			assert.Truef(t, constraint.Match(v), "%q should match %s", c.Constraint, s)
+		}
+		for _, s := range c.NoMatch {
+			v, err := ParseVersion(s)
+			require.NoError(t, err)
+			assert.Falsef(t, constraint.Match(v), "%q should not match %s", c.Constraint, s)
+		}
+	}
+}
+
+func Test_ParseConstraint_invalid(t *testing.T) {
+	for _, s := range []string{"", "^", "~a", "x", "1.2.3.4", "^01.2", ">=1.2 ||", "latest"} {
+		_, err := ParseConstraint(s)
+		assert.ErrorIsf(t, err, ErrInvalidConstraint, "constraint %q", s)
+	}
+}
@@ -56,7 +56,48 @@ func (v Version) Compare(ver Version) int {
 	} else if ver.Patch < v.Patch {
 		return 1
 	}
-	return strings.Compare(ver.PreRelease, v.PreRelease)
+	return comparePreRelease(v.PreRelease, ver.PreRelease)
+}
+
+// comparePreRelease compares pre-release versions by semver precedence,
+// version without pre-release has higher precedence.
+func comparePreRelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aErr == nil:
+			// numeric identifiers have lower precedence
+			return -1
+		case bErr == nil:
+			return 1
+		}
+		return strings.Compare(as[i], bs[i])
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
 }
 
 func (v Version) Latest(ver Version) Version {
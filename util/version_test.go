@@ -669,3 +669,30 @@ func Test_LatestTagVersion(t *testing.T) {
 	assert.Empty(t, l)
 	assert.Error(t, err)
 }
+
+func Test_Version_Compare_preRelease(t *testing.T) {
+	// ordered by semver precedence
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			c, err := CompareVersions(ordered[i], ordered[j])
+			require.NoError(t, err)
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			assert.Equalf(t, expected, c, "%s <=> %s", ordered[i], ordered[j])
+		}
+	}
+}